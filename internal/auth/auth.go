@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,32 +13,69 @@ import (
 
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
+const tokenPrefixLength = 8
+
+const (
+	AccessTokenAudience = "chirpy-access"
+	MFATokenAudience    = "chirpy-mfa"
+)
 
-	return string(hashed), nil
+type Claims struct {
+	jwt.StandardClaims
+	SessionID string `json:"sid,omitempty"`
+	Role      string `json:"role,omitempty"`
+	// Set on tokens issued to OAuth clients, per RFC 9068
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// IssuedAtMicro is iat to the microsecond, so that a revocation can
+	// tell apart tokens issued in the same second as it.
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
 }
 
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+type TokenOption func(*Claims)
+
+func WithAudience(audience string) TokenOption {
+	return func(c *Claims) {
+		c.Audience = audience
+	}
+}
 
+// WithSessionID ties an access token to the refresh token family it came from.
+func WithSessionID(sessionID uuid.UUID) TokenOption {
+	return func(c *Claims) {
+		c.SessionID = sessionID.String()
+	}
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: time.Now().Add(expiresIn).Unix(),
-		Subject:   userID.String(),
-	})
+func MakeJWT(userID uuid.UUID, keys *Keyring, expiresIn time.Duration, opts ...TokenOption) (string, error) {
+	key, err := keys.Current()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Issuer:    "chirpy",
+			Audience:  AccessTokenAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(expiresIn).Unix(),
+			Subject:   userID.String(),
+		},
+		IssuedAtMicro: now.UnixMicro(),
+	}
+	for _, opt := range opts {
+		opt(&claims)
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
 
-	signed, err := token.SignedString([]byte(tokenSecret))
+	signed, err := token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
@@ -44,20 +83,44 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return signed, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := jwt.StandardClaims{}
+// ParseJWT verifies a token minted by MakeJWT and returns its claims. Tokens
+// minted for a different audience, or revoked, are rejected.
+func ParseJWT(tokenString string, keys *Keyring, audience string) (*Claims, error) {
+	claims := Claims{}
 
 	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		// Verify the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+
+		// Verify the signing method matches the key, never trust alg on its own
+		if token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		// Return the secret key for HMAC
-		return []byte(tokenSecret), nil
+		return key.private.Public(), nil
 	}
 
 	_, err := jwt.ParseWithClaims(tokenString, &claims, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyAudience(audience, true) {
+		return nil, fmt.Errorf("token not intended for %s", audience)
+	}
+
+	if keys.revoked(&claims) {
+		return nil, ErrTokenRevoked
+	}
+
+	return &claims, nil
+}
+
+func ValidateJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, keys, AccessTokenAudience)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
@@ -102,3 +165,20 @@ func MakeRefreshToken() (string, error) {
 
 	return hexToken, nil
 }
+
+// HashToken returns the keyed hash under which an opaque token is stored, so
+// a copy of the database alone is not enough to replay it.
+func HashToken(token, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TokenPrefix is the non-secret leading part of a token kept alongside its
+// hash so a stored row can be matched to the token a user is holding.
+func TokenPrefix(token string) string {
+	if len(token) < tokenPrefixLength {
+		return token
+	}
+	return token[:tokenPrefixLength]
+}
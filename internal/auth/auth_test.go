@@ -2,22 +2,31 @@ package auth
 
 import (
 	"net/http"
-	"os"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+func newTestKeyring(t *testing.T, alg string) *Keyring {
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatalf("Error generating signing key: %s", err)
+	}
+
+	return NewKeyring(key)
+}
+
 func TestValidateJWT(t *testing.T) {
 	id := uuid.New()
+	keys := newTestKeyring(t, AlgRS256)
 
-	tokenString, err := MakeJWT(id, os.Getenv("TOKEN_SECRET"), time.Second)
+	tokenString, err := MakeJWT(id, keys, time.Second)
 	if err != nil {
 		t.Fatalf("Error making token")
 	}
 
-	_, err = ValidateJWT(tokenString, os.Getenv("TOKEN_SECRET"))
+	_, err = ValidateJWT(tokenString, keys)
 
 	if err != nil {
 		t.Fatalf("Failed to validate valid token: %s", err.Error())
@@ -28,12 +37,12 @@ func TestValidateJWT(t *testing.T) {
 func TestValidateJWTWrongSecret(t *testing.T) {
 	id := uuid.New()
 
-	tokenString, err := MakeJWT(id, "TEST_SECRET", time.Second)
+	tokenString, err := MakeJWT(id, newTestKeyring(t, AlgRS256), time.Second)
 	if err != nil {
 		t.Fatalf("Error making token")
 	}
 
-	_, err = ValidateJWT(tokenString, os.Getenv("TOKEN_SECRET"))
+	_, err = ValidateJWT(tokenString, newTestKeyring(t, AlgRS256))
 
 	if err == nil {
 		t.Fatalf("Validated token signed with wrong secret")
@@ -43,15 +52,16 @@ func TestValidateJWTWrongSecret(t *testing.T) {
 
 func TestValidateJWTExpired(t *testing.T) {
 	id := uuid.New()
+	keys := newTestKeyring(t, AlgEdDSA)
 
-	tokenString, err := MakeJWT(id, os.Getenv("TOKEN_SECRET"), time.Millisecond)
+	tokenString, err := MakeJWT(id, keys, time.Millisecond)
 	if err != nil {
 		t.Fatalf("Error making token")
 	}
 
 	time.Sleep(time.Second)
 
-	_, err = ValidateJWT(tokenString, os.Getenv("TOKEN_SECRET"))
+	_, err = ValidateJWT(tokenString, keys)
 
 	if err == nil {
 		t.Fatalf("Validated expired token")
@@ -95,3 +105,42 @@ func TestGetAPIToken(t *testing.T) {
 		t.Fatalf("token incorrectly parsed")
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	hashed := HashToken(token, "TEST_SECRET")
+	if hashed == token || hashed != HashToken(token, "TEST_SECRET") {
+		t.Fatalf("token hash is not a stable digest")
+	}
+
+	if hashed == HashToken(token, "OTHER_SECRET") {
+		t.Fatalf("token hash does not depend on key")
+	}
+
+	if TokenPrefix(token) != token[:8] {
+		t.Fatalf("token prefix incorrectly computed")
+	}
+}
+
+func TestValidateJWTWrongAudience(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+
+	tokenString, err := MakeJWT(uuid.New(), keys, time.Minute, WithAudience(MFATokenAudience))
+	if err != nil {
+		t.Fatalf("Error making token")
+	}
+
+	_, err = ValidateJWT(tokenString, keys)
+	if err == nil {
+		t.Fatalf("Validated MFA token as an access token")
+	}
+
+	_, err = ParseJWT(tokenString, keys, MFATokenAudience)
+	if err != nil {
+		t.Fatalf("Failed to parse MFA token: %s", err)
+	}
+}
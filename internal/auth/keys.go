@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a private key used to sign access tokens, identified by its kid.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	private   crypto.Signer
+}
+
+func GenerateSigningKey(alg string) (SigningKey, error) {
+	key := SigningKey{
+		ID:        uuid.NewString(),
+		Algorithm: alg,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	switch alg {
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.private = private
+	case AlgEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.private = private
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	return key, nil
+}
+
+func ParseSigningKey(id, alg, privatePEM string, createdAt time.Time) (SigningKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return SigningKey{}, errors.New("invalid PEM for signing key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key := SigningKey{
+		ID:        id,
+		Algorithm: alg,
+		CreatedAt: createdAt,
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if alg != AlgRS256 {
+			return SigningKey{}, fmt.Errorf("key %s is RSA but algorithm is %s", id, alg)
+		}
+		key.private = private
+	case ed25519.PrivateKey:
+		if alg != AlgEdDSA {
+			return SigningKey{}, fmt.Errorf("key %s is Ed25519 but algorithm is %s", id, alg)
+		}
+		key.private = private
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+func (k SigningKey) PrivateKeyPEM() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// sealedKeyPrefix marks a private key stored by SealPrivateKey, as opposed to
+// a PEM stored before keys were encrypted.
+const sealedKeyPrefix = "sealed:"
+
+// SealPrivateKey encrypts a private key PEM for storage under a key derived
+// from secret, so that a copy of the database alone can't sign tokens. The
+// key's ID is bound in, so a sealed key can't be swapped onto another row.
+func SealPrivateKey(id, privatePEM, secret string) (string, error) {
+	aead, err := privateKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(privatePEM), []byte(id))
+	return sealedKeyPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenPrivateKey returns the PEM of a stored private key. Keys stored before
+// they were encrypted come back as they are, with sealed false.
+func OpenPrivateKey(id, stored, secret string) (privatePEM string, sealed bool, err error) {
+	encoded, found := strings.CutPrefix(stored, sealedKeyPrefix)
+	if !found {
+		return stored, false, nil
+	}
+
+	aead, err := privateKeyCipher(secret)
+	if err != nil {
+		return "", true, err
+	}
+
+	dat, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", true, err
+	}
+	if len(dat) < aead.NonceSize() {
+		return "", true, errors.New("sealed signing key is too short")
+	}
+
+	nonce, ciphertext := dat[:aead.NonceSize()], dat[aead.NonceSize():]
+	opened, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", true, errors.New("signing key was sealed with a different secret")
+	}
+
+	return string(opened), true, nil
+}
+
+func privateKeyCipher(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, errors.New("no secret to seal signing keys with")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("chirpy signing keys"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// JSONWebKey is the public half of a signing key as published in a JWKS document.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func (k SigningKey) PublicJWK() JSONWebKey {
+	jwk := JSONWebKey{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Algorithm,
+	}
+
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+
+	return jwk
+}
+
+// PublicKey decodes a JWK into a key usable for verifying signatures.
+func (jwk JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.KeyType)
+	}
+}
+
+// Keyring holds every key that is still trusted for verification. The newest
+// key that is older than the activation delay is used for signing, so that
+// verifiers have fetched it from the JWKS before the first token appears.
+type Keyring struct {
+	mu              sync.RWMutex
+	keys            []SigningKey
+	revocations     *RevocationList
+	activationDelay time.Duration
+}
+
+func NewKeyring(keys ...SigningKey) *Keyring {
+	kr := &Keyring{}
+	kr.Replace(keys)
+	return kr
+}
+
+func (kr *Keyring) Replace(keys []SigningKey) {
+	sorted := make([]SigningKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys = sorted
+}
+
+// SetActivationDelay holds new keys back from signing until they are at least
+// delay old. Until then they are only published in the JWKS.
+func (kr *Keyring) SetActivationDelay(delay time.Duration) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.activationDelay = delay
+}
+
+// Current returns the key to sign with. If no key is old enough yet, as on
+// first boot, the oldest key is used.
+func (kr *Keyring) Current() (SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if len(kr.keys) == 0 {
+		return SigningKey{}, errors.New("keyring has no signing keys")
+	}
+
+	activeBefore := time.Now().Add(-kr.activationDelay)
+	for _, key := range kr.keys {
+		if !key.CreatedAt.After(activeBefore) {
+			return key, nil
+		}
+	}
+
+	return kr.keys[len(kr.keys)-1], nil
+}
+
+// Newest returns the most recently created key, whether or not it signs yet.
+func (kr *Keyring) Newest() (SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if len(kr.keys) == 0 {
+		return SigningKey{}, errors.New("keyring has no signing keys")
+	}
+
+	return kr.keys[0], nil
+}
+
+func (kr *Keyring) lookup(kid string) (SigningKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+
+	return SigningKey{}, false
+}
+
+func (kr *Keyring) JWKS() JSONWebKeySet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range kr.keys {
+		set.Keys = append(set.Keys, key.PublicJWK())
+	}
+
+	return set
+}
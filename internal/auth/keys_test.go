@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateJWTAfterRotation(t *testing.T) {
+	oldKey, err := GenerateSigningKey(AlgRS256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	keys := NewKeyring(oldKey)
+
+	tokenString, err := MakeJWT(uuid.New(), keys, time.Minute)
+	if err != nil {
+		t.Fatalf("Error making token: %s", err)
+	}
+
+	newKey, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	oldKey.CreatedAt = newKey.CreatedAt.Add(-time.Second)
+	keys.Replace([]SigningKey{oldKey, newKey})
+
+	current, _ := keys.Current()
+	if current.ID != newKey.ID {
+		t.Fatalf("Newest key is not used for signing")
+	}
+
+	_, err = ValidateJWT(tokenString, keys)
+	if err != nil {
+		t.Fatalf("Token signed by previous key rejected: %s", err)
+	}
+
+	keys.Replace([]SigningKey{newKey})
+
+	_, err = ValidateJWT(tokenString, keys)
+	if err == nil {
+		t.Fatalf("Validated token signed by retired key")
+	}
+}
+
+func TestActivationDelay(t *testing.T) {
+	oldKey, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	oldKey.CreatedAt = time.Now().Add(-time.Hour)
+
+	newKey, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	keys := NewKeyring(oldKey, newKey)
+	keys.SetActivationDelay(10 * time.Minute)
+
+	current, _ := keys.Current()
+	if current.ID != oldKey.ID {
+		t.Fatalf("New key signs before its activation delay")
+	}
+
+	newest, _ := keys.Newest()
+	if newest.ID != newKey.ID {
+		t.Fatalf("Newest returned %s, want %s", newest.ID, newKey.ID)
+	}
+
+	if len(keys.JWKS().Keys) != 2 {
+		t.Fatalf("Pending key is not published in the JWKS")
+	}
+
+	newKey.CreatedAt = time.Now().Add(-11 * time.Minute)
+	keys.Replace([]SigningKey{oldKey, newKey})
+
+	current, _ = keys.Current()
+	if current.ID != newKey.ID {
+		t.Fatalf("New key does not sign after its activation delay")
+	}
+
+	fresh, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	keys.Replace([]SigningKey{fresh})
+
+	current, err = keys.Current()
+	if err != nil || current.ID != fresh.ID {
+		t.Fatalf("Only key is not used for signing on first boot")
+	}
+}
+
+func TestSigningKeyPEMRoundTrip(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		encoded, err := key.PrivateKeyPEM()
+		if err != nil {
+			t.Fatalf("Error encoding %s key: %s", alg, err)
+		}
+
+		parsed, err := ParseSigningKey(key.ID, alg, encoded, key.CreatedAt)
+		if err != nil {
+			t.Fatalf("Error parsing %s key: %s", alg, err)
+		}
+
+		tokenString, err := MakeJWT(uuid.New(), NewKeyring(key), time.Minute)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		_, err = ValidateJWT(tokenString, NewKeyring(parsed))
+		if err != nil {
+			t.Fatalf("Parsed %s key does not verify original signature: %s", alg, err)
+		}
+	}
+}
+
+func TestSealPrivateKey(t *testing.T) {
+	key, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	privatePEM, err := key.PrivateKeyPEM()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	stored, err := SealPrivateKey(key.ID, privatePEM, "key-secret")
+	if err != nil {
+		t.Fatalf("Error sealing key: %s", err)
+	}
+	if strings.Contains(stored, "PRIVATE KEY") {
+		t.Fatalf("Sealed key is stored as PEM")
+	}
+
+	opened, sealed, err := OpenPrivateKey(key.ID, stored, "key-secret")
+	if err != nil || !sealed || opened != privatePEM {
+		t.Fatalf("Sealed key did not open to the original PEM: %v", err)
+	}
+
+	_, _, err = OpenPrivateKey(key.ID, stored, "other-secret")
+	if err == nil {
+		t.Fatalf("Sealed key opened with the wrong secret")
+	}
+
+	_, _, err = OpenPrivateKey("other-kid", stored, "key-secret")
+	if err == nil {
+		t.Fatalf("Sealed key opened for another key ID")
+	}
+
+	// Keys stored before sealing are read as they are
+	opened, sealed, err = OpenPrivateKey(key.ID, privatePEM, "key-secret")
+	if err != nil || sealed || opened != privatePEM {
+		t.Fatalf("Unsealed key not read as stored: %v", err)
+	}
+}
+
+func TestJWKSPublicKeys(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		set := NewKeyring(key).JWKS()
+		if len(set.Keys) != 1 || set.Keys[0].KeyID != key.ID {
+			t.Fatalf("JWKS does not contain signing key")
+		}
+
+		public, err := set.Keys[0].PublicKey()
+		if err != nil {
+			t.Fatalf("Error decoding %s JWK: %s", alg, err)
+		}
+
+		if !key.private.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(public) {
+			t.Fatalf("JWK public key does not match %s signing key", alg)
+		}
+	}
+}
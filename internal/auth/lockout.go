@@ -0,0 +1,29 @@
+package auth
+
+import "time"
+
+// LockoutPolicy allows FreeAttempts failures before locking, then doubles the
+// lockout for every further failure up to MaxLockout.
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	// Window is how long after the last failure the count starts again.
+	Window time.Duration
+}
+
+func (p LockoutPolicy) LockoutDuration(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	lockout := p.BaseLockout
+	for i := p.FreeAttempts; i < failures; i++ {
+		lockout *= 2
+		if lockout >= p.MaxLockout {
+			return p.MaxLockout
+		}
+	}
+
+	return lockout
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeAttempts: 3,
+		BaseLockout:  time.Second,
+		MaxLockout:   10 * time.Second,
+	}
+
+	cases := map[int]time.Duration{
+		0:   0,
+		2:   0,
+		3:   time.Second,
+		4:   2 * time.Second,
+		5:   4 * time.Second,
+		6:   8 * time.Second,
+		7:   10 * time.Second,
+		100: 10 * time.Second,
+	}
+
+	for failures, want := range cases {
+		got := policy.LockoutDuration(failures)
+		if got != want {
+			t.Fatalf("%d failures: got %s, want %s", failures, got, want)
+		}
+	}
+}
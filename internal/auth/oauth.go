@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+)
+
+const CodeChallengeMethodS256 = "S256"
+
+var ErrInvalidCodeVerifier = errors.New("code verifier does not match challenge")
+
+// RFC 7636 section 4.1: 43-128 unreserved characters.
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// WithClient marks an access token as issued to an OAuth client, limited to
+// scopes.
+func WithClient(clientID string, scopes []string) TokenOption {
+	return func(c *Claims) {
+		c.ClientID = clientID
+		c.Scope = FormatScopes(scopes)
+	}
+}
+
+func MakeCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCodeChallenge checks a PKCE verifier against the challenge sent with
+// the authorization request. Only S256 is supported; OAuth 2.1 drops plain.
+func VerifyCodeChallenge(verifier, challenge, method string) error {
+	if method != CodeChallengeMethodS256 {
+		return errors.New("unsupported code challenge method: " + method)
+	}
+	if !codeVerifierPattern.MatchString(verifier) {
+		return errors.New("malformed code verifier")
+	}
+
+	expected := CodeChallengeS256(verifier)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) != 1 {
+		return ErrInvalidCodeVerifier
+	}
+
+	return nil
+}
+
+// ValidateRedirectURI accepts absolute URIs without fragments. Plain http is
+// only allowed for loopback addresses used by native apps.
+func ValidateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if parsed.Fragment != "" || parsed.Host == "" {
+		return errors.New("redirect URI must be absolute and have no fragment")
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+
+	return errors.New("redirect URI must use https")
+}
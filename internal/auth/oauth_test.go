@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := CodeChallengeS256(verifier); got != challenge {
+		t.Fatalf("Expected challenge %s, got %s", challenge, got)
+	}
+
+	err := VerifyCodeChallenge(verifier, challenge, CodeChallengeMethodS256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	other, err := MakeCodeVerifier()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = VerifyCodeChallenge(other, challenge, CodeChallengeMethodS256)
+	if !errors.Is(err, ErrInvalidCodeVerifier) {
+		t.Fatalf("Expected ErrInvalidCodeVerifier, got %v", err)
+	}
+
+	err = VerifyCodeChallenge(verifier, verifier, "plain")
+	if err == nil {
+		t.Fatalf("Accepted plain code challenge method")
+	}
+
+	err = VerifyCodeChallenge("short", CodeChallengeS256("short"), CodeChallengeMethodS256)
+	if err == nil {
+		t.Fatalf("Accepted malformed code verifier")
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	valid := []string{
+		"https://example.com/callback",
+		"http://localhost:3000/callback",
+		"http://127.0.0.1/callback",
+	}
+	for _, uri := range valid {
+		if err := ValidateRedirectURI(uri); err != nil {
+			t.Errorf("Rejected %s: %s", uri, err)
+		}
+	}
+
+	invalid := []string{
+		"http://example.com/callback",
+		"https://example.com/callback#fragment",
+		"/callback",
+		"javascript:alert(1)",
+	}
+	for _, uri := range invalid {
+		if err := ValidateRedirectURI(uri); err == nil {
+			t.Errorf("Accepted %s", uri)
+		}
+	}
+}
+
+func TestClientAccessToken(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+	userID := uuid.New()
+
+	token, err := MakeJWT(userID, keys, time.Hour, WithClient("client-1", []string{ScopeChirpsRead, ScopeChirpsWrite}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	claims, err := ParseJWT(token, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if claims.ClientID != "client-1" || claims.Scope != "chirps:read chirps:write" {
+		t.Fatalf("Unexpected client claims: %q %q", claims.ClientID, claims.Scope)
+	}
+}
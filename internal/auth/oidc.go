@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// OIDCProvider is an external OpenID Connect identity provider that users can
+// sign in with. Create one with DiscoverOIDCProvider.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+	client                *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]JSONWebKey
+}
+
+// IDTokenClaims are the parts of a verified ID token Chirpy cares about.
+type IDTokenClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// DiscoverOIDCProvider fetches the provider's metadata from its well-known
+// configuration document.
+func DiscoverOIDCProvider(ctx context.Context, client *http.Client, issuer, clientID, clientSecret, redirectURL string) (*OIDCProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("OIDC discovery returned status %d", resp.StatusCode)
+	}
+
+	metadata := struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer mismatch: expected %s, got %s", issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	return &OIDCProvider{
+		Issuer:                issuer,
+		ClientID:              clientID,
+		ClientSecret:          clientSecret,
+		RedirectURL:           redirectURL,
+		authorizationEndpoint: metadata.AuthorizationEndpoint,
+		tokenEndpoint:         metadata.TokenEndpoint,
+		jwksURI:               metadata.JWKSURI,
+		client:                client,
+		keys:                  map[string]JSONWebKey{},
+	}, nil
+}
+
+// AuthCodeURL is where to send the user to sign in. state and nonce must be
+// checked again when they come back.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {"openid email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {CodeChallengeMethodS256},
+	}
+
+	separator := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.authorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades an authorization code for the user's ID token and verifies
+// it.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (IDTokenClaims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return IDTokenClaims{}, fmt.Errorf("OIDC token endpoint returned status %d", resp.StatusCode)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	if tokens.IDToken == "" {
+		return IDTokenClaims{}, errors.New("OIDC token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's JWKS,
+// and its issuer, audience, expiry and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (IDTokenClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: []string{AlgRS256, AlgEdDSA}}
+
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		jwk, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if jwk.Algorithm != "" && jwk.Algorithm != token.Method.Alg() {
+			return nil, fmt.Errorf("key %s is for %s, token uses %s", kid, jwk.Algorithm, token.Method.Alg())
+		}
+
+		return jwk.PublicKey()
+	})
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+
+	if !claims.VerifyIssuer(p.Issuer, true) {
+		return IDTokenClaims{}, errors.New("ID token has wrong issuer")
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return IDTokenClaims{}, errors.New("ID token has wrong audience")
+	}
+	if !claims.VerifyExpiresAt(jwt.TimeFunc().Unix(), true) {
+		return IDTokenClaims{}, errors.New("ID token has no expiry")
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || tokenNonce != nonce {
+		return IDTokenClaims{}, errors.New("ID token nonce mismatch")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return IDTokenClaims{}, errors.New("ID token has no subject")
+	}
+
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	return IDTokenClaims{
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: emailVerified,
+	}, nil
+}
+
+// key looks up a provider signing key, refetching the JWKS once when kid is
+// unknown in case the provider has rotated keys.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (JSONWebKey, error) {
+	p.mu.RLock()
+	jwk, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return jwk, nil
+	}
+
+	err := p.refreshKeys(ctx)
+	if err != nil {
+		return JSONWebKey{}, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	jwk, ok = p.keys[kid]
+	if !ok {
+		return JSONWebKey{}, fmt.Errorf("unknown OIDC signing key: %s", kid)
+	}
+	return jwk, nil
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.jwksURI, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("OIDC JWKS returned status %d", resp.StatusCode)
+	}
+
+	set := JSONWebKeySet{}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]JSONWebKey{}
+	for _, jwk := range set.Keys {
+		keys[jwk.KeyID] = jwk
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return nil
+}
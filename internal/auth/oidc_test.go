@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider. It accepts a single
+// authorization code and answers with an ID token built from claims.
+type mockOIDCProvider struct {
+	server        *httptest.Server
+	key           SigningKey
+	signer        SigningKey
+	code          string
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := GenerateSigningKey(AlgRS256)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	m := &mockOIDCProvider{key: key, signer: key, code: "mock-code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{m.key.PublicJWK()}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, ok := r.BasicAuth()
+		if !ok || clientID != "chirpy" || secret != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		if r.PostFormValue("code") != m.code || CodeChallengeS256(r.PostFormValue("code_verifier")) != m.codeChallenge {
+			w.WriteHeader(400)
+			return
+		}
+
+		token := jwt.NewWithClaims(m.signer.method(), m.claims)
+		token.Header["kid"] = m.key.ID
+		signed, err := token.SignedString(m.signer.private)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	m.claims = jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            []string{"chirpy"},
+		"sub":            "employee-42",
+		"email":          "employee@example.com",
+		"email_verified": true,
+		"nonce":          "the-nonce",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+
+	return m
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	ctx := context.Background()
+
+	provider, err := DiscoverOIDCProvider(ctx, mock.server.Client(), mock.server.URL, "chirpy", "secret", "http://localhost:8080/callback")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	verifier, err := MakeCodeVerifier()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	mock.codeChallenge = CodeChallengeS256(verifier)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("the-state", "the-nonce", mock.codeChallenge))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	query := authURL.Query()
+	if query.Get("state") != "the-state" || query.Get("nonce") != "the-nonce" || query.Get("code_challenge") != mock.codeChallenge {
+		t.Fatalf("Authorization URL missing parameters: %s", authURL)
+	}
+
+	claims, err := provider.Exchange(ctx, mock.code, verifier, "the-nonce")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if claims.Subject != "employee-42" || claims.Email != "employee@example.com" || !claims.EmailVerified {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestOIDCLoginRejectsBadIDTokens(t *testing.T) {
+	ctx := context.Background()
+
+	cases := map[string]func(m *mockOIDCProvider){
+		"wrong nonce":    func(m *mockOIDCProvider) { m.claims["nonce"] = "other-nonce" },
+		"wrong audience": func(m *mockOIDCProvider) { m.claims["aud"] = "someone-else" },
+		"wrong issuer":   func(m *mockOIDCProvider) { m.claims["iss"] = "https://evil.example.com" },
+		"expired":        func(m *mockOIDCProvider) { m.claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"bad signature": func(m *mockOIDCProvider) {
+			m.signer, _ = GenerateSigningKey(AlgRS256)
+		},
+	}
+
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			mock := newMockOIDCProvider(t)
+
+			provider, err := DiscoverOIDCProvider(ctx, mock.server.Client(), mock.server.URL, "chirpy", "secret", "http://localhost:8080/callback")
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+
+			verifier, _ := MakeCodeVerifier()
+			mock.codeChallenge = CodeChallengeS256(verifier)
+			tamper(mock)
+
+			_, err = provider.Exchange(ctx, mock.code, verifier, "the-nonce")
+			if err == nil {
+				t.Fatalf("Accepted ID token with %s", name)
+			}
+		})
+	}
+}
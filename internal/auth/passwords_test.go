@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	hasher := Argon2idHasher{Params: testArgon2idParams}
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash not encoded with its parameters: %s", hash)
+	}
+
+	err = hasher.Verify("correct horse", hash)
+	if err != nil {
+		t.Fatalf("Failed to verify correct password: %s", err)
+	}
+
+	err = hasher.Verify("battery staple", hash)
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("Verified wrong password")
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Fatalf("Fresh hash flagged for rehash")
+	}
+}
+
+func TestArgon2idLongPasswords(t *testing.T) {
+	hasher := Argon2idHasher{Params: testArgon2idParams}
+	prefix := strings.Repeat("a", 80)
+
+	hash, err := hasher.Hash(prefix + "1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if hasher.Verify(prefix+"2", hash) == nil {
+		t.Fatalf("Password truncated before hashing")
+	}
+}
+
+func TestArgon2idNeedsRehashOnParamChange(t *testing.T) {
+	old := Argon2idHasher{Params: testArgon2idParams}
+
+	hash, err := old.Hash("password")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	stronger := testArgon2idParams
+	stronger.Iterations = 2
+	current := Argon2idHasher{Params: stronger}
+
+	if !current.NeedsRehash(hash) {
+		t.Fatalf("Outdated parameters not flagged for rehash")
+	}
+
+	err = current.Verify("password", hash)
+	if err != nil {
+		t.Fatalf("Failed to verify hash with outdated parameters: %s", err)
+	}
+}
+
+func TestLegacyBcryptHash(t *testing.T) {
+	legacy, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("password")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	hasher := NewPasswordHasher(Argon2idHasher{Params: testArgon2idParams}, BcryptHasher{Cost: bcrypt.MinCost})
+
+	err = hasher.Verify("password", legacy)
+	if err != nil {
+		t.Fatalf("Failed to verify legacy bcrypt hash: %s", err)
+	}
+
+	if hasher.Verify("wrong", legacy) == nil {
+		t.Fatalf("Verified wrong password against bcrypt hash")
+	}
+
+	if !hasher.NeedsRehash(legacy) {
+		t.Fatalf("Legacy bcrypt hash not flagged for rehash")
+	}
+
+	upgraded, err := hasher.Hash("password")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if hasher.NeedsRehash(upgraded) {
+		t.Fatalf("Upgraded hash flagged for rehash")
+	}
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRevokedToken(t *testing.T) {
+	keys := newTestKeyring(t, AlgRS256)
+	revocations := NewRevocationList()
+	keys.SetRevocationList(revocations)
+
+	token, err := MakeJWT(uuid.New(), keys, time.Hour)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	other, err := MakeJWT(uuid.New(), keys, time.Hour)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	claims, err := ParseJWT(token, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if claims.Id == "" {
+		t.Fatalf("Token has no jti")
+	}
+
+	revocations.Add(RevokedTokenKey(claims.Id), time.Now())
+
+	_, err = ParseJWT(token, keys, AccessTokenAudience)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked, got %v", err)
+	}
+
+	_, err = ParseJWT(other, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("Revoking one token revoked another: %s", err)
+	}
+}
+
+func TestRevokedUserAndSession(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+	revocations := NewRevocationList()
+	keys.SetRevocationList(revocations)
+
+	userID := uuid.New()
+	sessionID := uuid.New()
+
+	userToken, err := MakeJWT(userID, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	sessionToken, err := MakeJWT(uuid.New(), keys, time.Hour, WithSessionID(sessionID))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	revocations.Add(RevokedUserKey(userID), time.Now().Add(time.Second))
+	revocations.Add(RevokedSessionKey(sessionID), time.Now().Add(time.Second))
+
+	for _, token := range []string{userToken, sessionToken} {
+		_, err = ParseJWT(token, keys, AccessTokenAudience)
+		if !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("Expected ErrTokenRevoked, got %v", err)
+		}
+	}
+
+	// Tokens issued after the revocation are unaffected
+	revocations.Replace(map[string]time.Time{
+		RevokedUserKey(userID): time.Now().Add(-time.Minute),
+	})
+
+	_, err = ParseJWT(userToken, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("Token issued after revocation was rejected: %s", err)
+	}
+}
+
+func TestRevokedUserClients(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+	revocations := NewRevocationList()
+	keys.SetRevocationList(revocations)
+
+	userID := uuid.New()
+
+	clientToken, err := MakeJWT(userID, keys, time.Hour, WithClient("client", []string{ScopeChirpsRead}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	sessionToken, err := MakeJWT(userID, keys, time.Hour, WithSessionID(uuid.New()))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	revocations.Add(RevokedUserClientsKey(userID), time.Now().Add(time.Second))
+
+	_, err = ParseJWT(clientToken, keys, AccessTokenAudience)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked, got %v", err)
+	}
+
+	_, err = ParseJWT(sessionToken, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("Session token rejected by client revocation: %s", err)
+	}
+}
+
+func TestRevokedWithinTheSecond(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+	revocations := NewRevocationList()
+	keys.SetRevocationList(revocations)
+
+	userID := uuid.New()
+
+	before, err := MakeJWT(userID, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	revokedAt := time.Now()
+	time.Sleep(time.Millisecond)
+
+	after, err := MakeJWT(userID, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	revocations.Add(RevokedUserKey(userID), revokedAt)
+
+	_, err = ParseJWT(before, keys, AccessTokenAudience)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Token issued just before the revocation: expected ErrTokenRevoked, got %v", err)
+	}
+
+	_, err = ParseJWT(after, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("Token issued just after the revocation was rejected: %s", err)
+	}
+
+	// Without iat_us only the second is known, so err on revoking
+	legacy := &Claims{}
+	legacy.Subject = userID.String()
+	legacy.IssuedAt = revokedAt.Unix()
+	if !revocations.Revoked(legacy) {
+		t.Fatalf("Token without iat_us issued in the revocation's second was not revoked")
+	}
+}
+
+func TestRevokedClientAndGrant(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+	revocations := NewRevocationList()
+	keys.SetRevocationList(revocations)
+
+	userID := uuid.New()
+	otherUserID := uuid.New()
+
+	mint := func(userID uuid.UUID, clientID string) string {
+		t.Helper()
+
+		token, err := MakeJWT(userID, keys, time.Hour, WithClient(clientID, []string{ScopeChirpsRead}))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		return token
+	}
+
+	granted := mint(userID, "app")
+	otherApp := mint(userID, "other-app")
+	otherUser := mint(otherUserID, "app")
+
+	revocations.Add(RevokedGrantKey(userID, "app"), time.Now().Add(time.Second))
+
+	_, err := ParseJWT(granted, keys, AccessTokenAudience)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked, got %v", err)
+	}
+	for _, token := range []string{otherApp, otherUser} {
+		_, err = ParseJWT(token, keys, AccessTokenAudience)
+		if err != nil {
+			t.Fatalf("Token from another grant rejected: %s", err)
+		}
+	}
+
+	revocations.Add(RevokedClientKey("app"), time.Now().Add(time.Second))
+
+	_, err = ParseJWT(otherUser, keys, AccessTokenAudience)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked, got %v", err)
+	}
+	_, err = ParseJWT(otherApp, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("Token from another client rejected: %s", err)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHasRole(t *testing.T) {
+	cases := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleUser, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleUser, RoleModerator, false},
+		{"", RoleUser, false},
+		{"superuser", RoleUser, false},
+	}
+
+	for _, c := range cases {
+		if got := HasRole(c.role, c.required); got != c.want {
+			t.Fatalf("HasRole(%q, %q) = %v, want %v", c.role, c.required, got, c.want)
+		}
+	}
+}
+
+func TestRoleClaim(t *testing.T) {
+	keys := newTestKeyring(t, AlgEdDSA)
+
+	tokenString, err := MakeJWT(uuid.New(), keys, time.Minute, WithRole(RoleModerator))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	claims, err := ParseJWT(tokenString, keys, AccessTokenAudience)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if claims.Role != RoleModerator {
+		t.Fatalf("role claim not embedded: %q", claims.Role)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+var KnownScopes = []string{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+}
+
+// ValidateScopes rejects unknown scopes and returns the rest sorted and
+// deduplicated.
+func ValidateScopes(scopes []string) ([]string, error) {
+	valid := []string{}
+	for _, scope := range scopes {
+		if !slices.Contains(KnownScopes, scope) {
+			return nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+		valid = append(valid, scope)
+	}
+
+	slices.Sort(valid)
+	return slices.Compact(valid), nil
+}
+
+// Scopes are stored and transmitted space separated, as in OAuth.
+func ParseScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
+
+func FormatScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+func HasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope)
+}
+
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidateScopes(t *testing.T) {
+	scopes, err := ValidateScopes([]string{ScopeChirpsWrite, ScopeChirpsRead, ScopeChirpsWrite})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !slices.Equal(scopes, []string{ScopeChirpsRead, ScopeChirpsWrite}) {
+		t.Fatalf("scopes not sorted and deduplicated: %v", scopes)
+	}
+
+	_, err = ValidateScopes([]string{"admin:everything"})
+	if err == nil {
+		t.Fatalf("Accepted unknown scope")
+	}
+}
+
+func TestScopesRoundTrip(t *testing.T) {
+	scopes := []string{ScopeChirpsRead, ScopeProfileWrite}
+
+	parsed := ParseScopes(FormatScopes(scopes))
+	if !slices.Equal(parsed, scopes) {
+		t.Fatalf("scopes changed in round trip: %v", parsed)
+	}
+
+	if !HasScope(parsed, ScopeProfileWrite) || HasScope(parsed, ScopeChirpsWrite) {
+		t.Fatalf("HasScope gave wrong answer")
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !IsPersonalAccessToken(token) {
+		t.Fatalf("personal access token not recognized: %s", token)
+	}
+
+	refresh, _ := MakeRefreshToken()
+	if IsPersonalAccessToken(refresh) {
+		t.Fatalf("refresh token mistaken for personal access token")
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSignedToken(t *testing.T) {
+	payload := SignedToken{
+		ID:        uuid.NewString(),
+		Purpose:   PurposeVerifyEmail,
+		Subject:   uuid.NewString(),
+		Email:     "user@example.com",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}
+
+	token, err := MakeSignedToken(payload, "TEST_SECRET")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	parsed, err := ParseSignedToken(token, "TEST_SECRET", PurposeVerifyEmail)
+	if err != nil {
+		t.Fatalf("Failed to parse valid token: %s", err)
+	}
+
+	if parsed != payload {
+		t.Fatalf("payload changed in round trip")
+	}
+
+	_, err = ParseSignedToken(token, "OTHER_SECRET", PurposeVerifyEmail)
+	if err == nil {
+		t.Fatalf("Parsed token signed with wrong secret")
+	}
+
+	_, err = ParseSignedToken(token, "TEST_SECRET", "other-purpose")
+	if err == nil {
+		t.Fatalf("Parsed token issued for another purpose")
+	}
+}
+
+func TestSignedTokenExpired(t *testing.T) {
+	token, err := MakeSignedToken(SignedToken{
+		ID:        uuid.NewString(),
+		Purpose:   PurposeVerifyEmail,
+		ExpiresAt: time.Now().Add(-time.Second).Unix(),
+	}, "TEST_SECRET")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = ParseSignedToken(token, "TEST_SECRET", PurposeVerifyEmail)
+	if err == nil {
+		t.Fatalf("Parsed expired token")
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 secret, truncated to six digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if got != want {
+			t.Fatalf("code at %d: got %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now.Add(-30*time.Second))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	step, err := ValidateTOTP(secret, code, now, 0)
+	if err != nil {
+		t.Fatalf("Rejected code from previous step: %s", err)
+	}
+
+	_, err = ValidateTOTP(secret, code, now, step)
+	if err == nil {
+		t.Fatalf("Accepted replayed code")
+	}
+
+	stale, _ := TOTPCode(secret, now.Add(-5*time.Minute))
+	_, err = ValidateTOTP(secret, stale, now, 0)
+	if err == nil {
+		t.Fatalf("Accepted code outside the allowed skew")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "user@example.com", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?") {
+		t.Fatalf("unexpected otpauth URI: %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Chirpy") {
+		t.Fatalf("otpauth URI missing parameters: %s", uri)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("badly formatted recovery code: %s", code)
+		}
+
+		normalized := NormalizeRecoveryCode(" " + strings.ToUpper(code) + " ")
+		if normalized != strings.ReplaceAll(code, "-", "") {
+			t.Fatalf("recovery code did not normalize: %s", normalized)
+		}
+
+		if seen[code] {
+			t.Fatalf("duplicate recovery code")
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWebhookTolerance is how far a webhook's timestamp may be from the
+// current time before it is treated as a replay.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+var (
+	ErrMissingWebhookSignature = errors.New("webhook is not signed")
+	ErrInvalidWebhookSignature = errors.New("webhook signature does not match")
+	ErrStaleWebhook            = errors.New("webhook timestamp outside tolerance")
+)
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.body", which is what
+// the sender puts in its signature header.
+func SignWebhook(body []byte, timestamp int64, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks that body was signed within tolerance of now
+// with any of secrets, so an old and a new secret can both be accepted while
+// the secret is being rotated. signatures may hold several comma-separated
+// signatures for the same reason on the sender's side. timestamp and
+// signatures come from the provider's webhook headers.
+func VerifyWebhookSignature(body []byte, timestamp, signatures string, secrets []string, tolerance time.Duration, now time.Time) error {
+	if signatures == "" || timestamp == "" {
+		return ErrMissingWebhookSignature
+	}
+
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp: %w", err)
+	}
+
+	sent := time.Unix(sentAt, 0)
+	if sent.Before(now.Add(-tolerance)) || sent.After(now.Add(tolerance)) {
+		return ErrStaleWebhook
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		expected := SignWebhook(body, sentAt, secret)
+
+		for _, signature := range strings.Split(signatures, ",") {
+			if hmac.Equal([]byte(strings.TrimSpace(signature)), []byte(expected)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidWebhookSignature
+}
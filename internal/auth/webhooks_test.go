@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signedWebhook returns the timestamp and signature headers for body.
+func signedWebhook(body []byte, sent time.Time, secret string) (string, string) {
+	return strconv.FormatInt(sent.Unix(), 10), SignWebhook(body, sent.Unix(), secret)
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	now := time.Now()
+	secrets := []string{"NEW_SECRET", "OLD_SECRET"}
+
+	type signed struct {
+		timestamp  string
+		signatures string
+	}
+	sign := func(sent time.Time, secret string) signed {
+		timestamp, signature := signedWebhook(body, sent, secret)
+		return signed{timestamp, signature}
+	}
+
+	cases := map[string]struct {
+		signed signed
+		body   []byte
+		want   error
+	}{
+		"current secret":   {sign(now, "NEW_SECRET"), body, nil},
+		"previous secret":  {sign(now, "OLD_SECRET"), body, nil},
+		"within tolerance": {sign(now.Add(-4*time.Minute), "NEW_SECRET"), body, nil},
+		"unknown secret":   {sign(now, "OTHER_SECRET"), body, ErrInvalidWebhookSignature},
+		"tampered body":    {sign(now, "NEW_SECRET"), []byte(`{"event":"user.upgraded"}`), ErrInvalidWebhookSignature},
+		"replayed":         {sign(now.Add(-10*time.Minute), "NEW_SECRET"), body, ErrStaleWebhook},
+		"from the future":  {sign(now.Add(10*time.Minute), "NEW_SECRET"), body, ErrStaleWebhook},
+		"unsigned":         {signed{}, body, ErrMissingWebhookSignature},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := VerifyWebhookSignature(c.body, c.signed.timestamp, c.signed.signatures, secrets, DefaultWebhookTolerance, now)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookSignatureRejectsMovedTimestamp(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded"}`)
+
+	_, signature := signedWebhook(body, time.Now().Add(-time.Minute), "SECRET")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	err := VerifyWebhookSignature(body, timestamp, signature, []string{"SECRET"}, DefaultWebhookTolerance, time.Now())
+	if !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Fatalf("Accepted signature after the timestamp was changed: %v", err)
+	}
+}
+
+func TestVerifyWebhookSignatureAcceptsSeveralSignatures(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded"}`)
+	now := time.Now()
+
+	timestamp, oldSignature := signedWebhook(body, now, "OLD_SECRET")
+	signatures := oldSignature + ", " + SignWebhook(body, now.Unix(), "NEW_SECRET")
+
+	err := VerifyWebhookSignature(body, timestamp, signatures, []string{"NEW_SECRET"}, DefaultWebhookTolerance, now)
+	if err != nil {
+		t.Fatalf("Rejected webhook carrying a valid signature: %s", err)
+	}
+}
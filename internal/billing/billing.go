@@ -0,0 +1,215 @@
+package billing
+
+import (
+	"chirpy/internal/auth"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Change is what a payment event does to a user's Chirpy Red subscription.
+type Change string
+
+const (
+	// ChangeNone is for events that do not affect the subscription.
+	ChangeNone    Change = "none"
+	ChangeStart   Change = "start"
+	ChangeRenew   Change = "renew"
+	ChangePastDue Change = "past_due"
+	ChangeCancel  Change = "cancel"
+	ChangeEnd     Change = "end"
+)
+
+// Event is a payment webhook in a form that does not depend on the provider
+// that sent it.
+type Event struct {
+	// ID is the provider's ID for the event, used to spot redeliveries.
+	// When the provider sent none it is made up from the delivery instead,
+	// and IDFromProvider is false.
+	ID             string
+	IDFromProvider bool
+	// Type is the provider's own name for the event.
+	Type   string
+	UserID uuid.UUID
+	// PeriodStart and PeriodEnd bound the period paid for, when the provider
+	// says so.
+	PeriodStart *time.Time
+	PeriodEnd   *time.Time
+}
+
+// Provider is a payment processor that tells Chirpy about subscriptions by
+// webhook.
+type Provider interface {
+	// VerifyRequest checks that a webhook really came from the provider.
+	VerifyRequest(r *http.Request, body []byte) error
+	// ParseEvent reads a verified webhook. The delivery's headers are only
+	// used for the event ID, and may be nil once the event is stored.
+	ParseEvent(header http.Header, body []byte) (Event, error)
+	EntitlementChange(event Event) Change
+}
+
+// eventID is the provider's ID for the event when it sent one. Without one,
+// a genuine repeat such as a second upgrade has the same body as a retry, so
+// the ID also covers the signed delivery timestamp. Unsigned events get a
+// random ID and are never treated as duplicates. A retry signed afresh is
+// therefore not recognised either, so only events with a provider ID may
+// change a subscription in a way that repeating would compound.
+func eventID(id, timestamp string, body []byte) string {
+	if id != "" {
+		return id
+	}
+	if timestamp == "" {
+		return "unsigned:" + uuid.NewString()
+	}
+	sum := sha256.Sum256(body)
+	return "delivery:" + timestamp + ":" + hex.EncodeToString(sum[:])
+}
+
+const (
+	PolkaSignatureHeader = "X-Polka-Signature"
+	PolkaTimestampHeader = "X-Polka-Timestamp"
+)
+
+// Polka sends signed webhooks. APIKey is the static key Polka used before it
+// signed webhooks; while it is set, unsigned webhooks carrying it in an
+// "ApiKey" Authorization header are still accepted.
+type Polka struct {
+	APIKey string
+	// Secrets verify signed webhooks. Any of them is accepted so the secret
+	// can be rotated without dropping webhooks.
+	Secrets []string
+}
+
+func (p Polka) VerifyRequest(r *http.Request, body []byte) error {
+	if r.Header.Get(PolkaSignatureHeader) != "" || p.APIKey == "" {
+		return auth.VerifyWebhookSignature(body, r.Header.Get(PolkaTimestampHeader), r.Header.Get(PolkaSignatureHeader), p.Secrets, auth.DefaultWebhookTolerance, time.Now())
+	}
+
+	key, err := auth.GetToken(r.Header, "ApiKey ")
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key), []byte(p.APIKey)) != 1 {
+		return errors.New("invalid API key")
+	}
+
+	return nil
+}
+
+func (p Polka) ParseEvent(header http.Header, body []byte) (Event, error) {
+	type data struct {
+		UserId      uuid.UUID  `json:"user_id"`
+		PeriodStart *time.Time `json:"period_start"`
+		PeriodEnd   *time.Time `json:"period_end"`
+	}
+
+	type parameters struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+		Data  data   `json:"data"`
+	}
+
+	params := parameters{}
+	err := json.Unmarshal(body, &params)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:             eventID(params.ID, header.Get(PolkaTimestampHeader), body),
+		IDFromProvider: params.ID != "",
+		Type:           params.Event,
+		UserID:         params.Data.UserId,
+		PeriodStart:    params.Data.PeriodStart,
+		PeriodEnd:      params.Data.PeriodEnd,
+	}, nil
+}
+
+func (p Polka) EntitlementChange(event Event) Change {
+	switch event.Type {
+	case "user.upgraded":
+		return ChangeStart
+	case "subscription.renewed":
+		return ChangeRenew
+	case "subscription.payment_failed":
+		return ChangePastDue
+	case "subscription.canceled":
+		return ChangeCancel
+	case "user.downgraded":
+		return ChangeEnd
+	default:
+		return ChangeNone
+	}
+}
+
+const (
+	GenericSignatureHeader = "X-Webhook-Signature"
+	GenericTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// Generic is for any processor, or glue in front of one, that can send
+// Chirpy's own webhook format:
+//
+//	{"id": "evt_1", "type": "subscription.started", "user_id": "...",
+//	 "period_start": "2024-01-01T00:00:00Z", "period_end": "2024-02-01T00:00:00Z"}
+//
+// signed the same way as Polka's webhooks but in the X-Webhook-Timestamp and
+// X-Webhook-Signature headers. The types are subscription.started,
+// subscription.renewed, subscription.past_due, subscription.canceled and
+// subscription.ended.
+type Generic struct {
+	Secrets []string
+}
+
+func (g Generic) VerifyRequest(r *http.Request, body []byte) error {
+	return auth.VerifyWebhookSignature(body, r.Header.Get(GenericTimestampHeader), r.Header.Get(GenericSignatureHeader), g.Secrets, auth.DefaultWebhookTolerance, time.Now())
+}
+
+func (g Generic) ParseEvent(header http.Header, body []byte) (Event, error) {
+	type parameters struct {
+		ID          string     `json:"id"`
+		Type        string     `json:"type"`
+		UserID      uuid.UUID  `json:"user_id"`
+		PeriodStart *time.Time `json:"period_start"`
+		PeriodEnd   *time.Time `json:"period_end"`
+	}
+
+	params := parameters{}
+	err := json.Unmarshal(body, &params)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:             eventID(params.ID, header.Get(GenericTimestampHeader), body),
+		IDFromProvider: params.ID != "",
+		Type:           params.Type,
+		UserID:         params.UserID,
+		PeriodStart:    params.PeriodStart,
+		PeriodEnd:      params.PeriodEnd,
+	}, nil
+}
+
+func (g Generic) EntitlementChange(event Event) Change {
+	switch event.Type {
+	case "subscription.started":
+		return ChangeStart
+	case "subscription.renewed":
+		return ChangeRenew
+	case "subscription.past_due":
+		return ChangePastDue
+	case "subscription.canceled":
+		return ChangeCancel
+	case "subscription.ended":
+		return ChangeEnd
+	default:
+		return ChangeNone
+	}
+}
@@ -0,0 +1,136 @@
+package billing
+
+import (
+	"chirpy/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func signedRequest(body []byte, timestampHeader, signatureHeader, secret string) *http.Request {
+	now := time.Now().Unix()
+
+	r := httptest.NewRequest("POST", "/api/webhooks/test", nil)
+	r.Header.Set(timestampHeader, strconv.FormatInt(now, 10))
+	r.Header.Set(signatureHeader, auth.SignWebhook(body, now, secret))
+	return r
+}
+
+func TestPolkaVerifyRequest(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+
+	signed := signedRequest(body, PolkaTimestampHeader, PolkaSignatureHeader, "SECRET")
+
+	withKey := httptest.NewRequest("POST", "/api/webhooks/polka", nil)
+	withKey.Header.Set("Authorization", "ApiKey KEY")
+
+	err := Polka{Secrets: []string{"SECRET"}}.VerifyRequest(signed, body)
+	if err != nil {
+		t.Fatalf("Rejected signed webhook: %s", err)
+	}
+
+	err = Polka{Secrets: []string{"OTHER"}, APIKey: "KEY"}.VerifyRequest(signed, body)
+	if err == nil {
+		t.Fatalf("Accepted webhook signed with unknown secret")
+	}
+
+	err = Polka{Secrets: []string{"SECRET"}, APIKey: "KEY"}.VerifyRequest(withKey, body)
+	if err != nil {
+		t.Fatalf("Rejected webhook with API key: %s", err)
+	}
+
+	err = Polka{Secrets: []string{"SECRET"}}.VerifyRequest(withKey, body)
+	if err == nil {
+		t.Fatalf("Accepted unsigned webhook with API key fallback disabled")
+	}
+
+	err = Polka{APIKey: "OTHER"}.VerifyRequest(withKey, body)
+	if err == nil {
+		t.Fatalf("Accepted webhook with wrong API key")
+	}
+}
+
+func TestPolkaParseEvent(t *testing.T) {
+	userID := uuid.New()
+	body := []byte(`{"id":"evt_1","event":"subscription.renewed","data":{"user_id":"` + userID.String() + `","period_end":"2030-02-01T00:00:00Z"}}`)
+
+	event, err := Polka{}.ParseEvent(nil, body)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if event.ID != "evt_1" || !event.IDFromProvider || event.UserID != userID || event.PeriodStart != nil || event.PeriodEnd == nil {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	if change := (Polka{}).EntitlementChange(event); change != ChangeRenew {
+		t.Fatalf("got %s, want %s", change, ChangeRenew)
+	}
+}
+
+func TestEventIDWithoutProviderID(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	delivery := func(timestamp string) http.Header {
+		return http.Header{PolkaTimestampHeader: {timestamp}}
+	}
+
+	first, err := Polka{}.ParseEvent(delivery("1700000000"), body)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	retry, _ := Polka{}.ParseEvent(delivery("1700000000"), body)
+	repeat, _ := Polka{}.ParseEvent(delivery("1700003600"), body)
+
+	if first.IDFromProvider {
+		t.Fatalf("Made up event ID reported as the provider's")
+	}
+	if first.ID == "" || first.ID != retry.ID {
+		t.Fatalf("Redelivered webhook got a different ID: %q, %q", first.ID, retry.ID)
+	}
+	if first.ID == repeat.ID {
+		t.Fatalf("Identical events sent at different times got the same ID")
+	}
+
+	unsigned, _ := Polka{}.ParseEvent(nil, body)
+	unsignedAgain, _ := Polka{}.ParseEvent(nil, body)
+	if unsigned.ID == "" || unsigned.ID == unsignedAgain.ID {
+		t.Fatalf("Unsigned webhooks without an ID were treated as duplicates")
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	userID := uuid.New()
+	body := []byte(`{"id":"evt_2","type":"subscription.canceled","user_id":"` + userID.String() + `"}`)
+	provider := Generic{Secrets: []string{"SECRET"}}
+
+	err := provider.VerifyRequest(signedRequest(body, GenericTimestampHeader, GenericSignatureHeader, "SECRET"), body)
+	if err != nil {
+		t.Fatalf("Rejected signed webhook: %s", err)
+	}
+
+	err = provider.VerifyRequest(signedRequest(body, PolkaTimestampHeader, PolkaSignatureHeader, "SECRET"), body)
+	if err == nil {
+		t.Fatalf("Accepted webhook signed in Polka's headers")
+	}
+
+	event, err := provider.ParseEvent(nil, body)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if event.ID != "evt_2" || event.UserID != userID {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	if change := provider.EntitlementChange(event); change != ChangeCancel {
+		t.Fatalf("got %s, want %s", change, ChangeCancel)
+	}
+
+	if change := provider.EntitlementChange(Event{Type: "invoice.created"}); change != ChangeNone {
+		t.Fatalf("got %s for unrelated event", change)
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	auditLogin          = "login"
+	auditSecondFactor   = "login.second_factor"
+	auditLogout         = "logout"
+	auditSessionRevoke  = "session.revoke"
+	auditTokenReuse     = "session.refresh_token_reuse"
+	auditTokenRevoke    = "token.revoke"
+	auditUserUpdate     = "user.update"
+	auditPasswordChange = "user.password_change"
+	auditPasswordReset  = "user.password_reset"
+	auditChirpyRed      = "user.chirpy_red"
+	auditRoleChange     = "admin.role_change"
+	auditSuspend        = "admin.suspend"
+	auditUnsuspend      = "admin.unsuspend"
+	auditClearLockout   = "admin.clear_lockout"
+	auditResetMetrics   = "admin.reset_metrics"
+	auditReplayWebhook  = "admin.replay_webhook"
+
+	auditSuccess = "success"
+	auditFailure = "failure"
+	auditDenied  = "denied"
+
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// parsePage reads the optional limit and offset query parameters used by the
+// admin list endpoints.
+func parsePage(query url.Values, defaultLimit, maxLimit int) (int32, int32, error) {
+	limit, err := parseLimit(query, defaultLimit, maxLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset := 0
+	if query.Get("offset") != "" {
+		parsed, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = parsed
+	}
+
+	return limit, int32(offset), nil
+}
+
+// parseLimit reads the optional limit query parameter of a list endpoint.
+func parseLimit(query url.Values, defaultLimit, maxLimit int) (int32, error) {
+	if query.Get("limit") == "" {
+		return int32(defaultLimit), nil
+	}
+
+	parsed, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || parsed < 1 || parsed > maxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return int32(parsed), nil
+}
+
+// auditEntry describes one audit event. ActorID is whoever made the request
+// and SubjectID the account it affected; either is uuid.Nil when unknown.
+type auditEntry struct {
+	Event     string
+	Outcome   string
+	ActorID   uuid.UUID
+	SubjectID uuid.UUID
+	Details   string
+}
+
+// audit records an event in the append-only audit log. Failing to write it is
+// logged but never fails the request being audited.
+func (cfg *ApiConfig) audit(r *http.Request, entry auditEntry) {
+	eventParams := database.CreateAuditEventParams{
+		Event:     entry.Event,
+		Outcome:   entry.Outcome,
+		ActorID:   uuid.NullUUID{UUID: entry.ActorID, Valid: entry.ActorID != uuid.Nil},
+		SubjectID: uuid.NullUUID{UUID: entry.SubjectID, Valid: entry.SubjectID != uuid.Nil},
+		Ip:        cfg.clientIP(r),
+		UserAgent: r.UserAgent(),
+		Details:   entry.Details,
+	}
+
+	err := cfg.Db.CreateAuditEvent(r.Context(), eventParams)
+	if err != nil {
+		log.Printf("Error writing audit event %s: %s", entry.Event, err)
+	}
+}
+
+// adminActor is the admin making a request that went through RequireRole.
+func adminActor(r *http.Request) uuid.UUID {
+	caller, _ := principalFromContext(r.Context())
+	return caller.UserID
+}
+
+// AuditEventsHandler lists audit events newest first. Every query parameter
+// is optional: event, outcome, actor_id, subject_id, since and until (RFC
+// 3339) filter the results, and limit and offset page through them.
+func (cfg *ApiConfig) AuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, offset, err := parsePage(query, defaultAuditPageSize, maxAuditPageSize)
+	if err != nil {
+		log.Printf("Invalid page: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	eventsParams := database.GetAuditEventsParams{
+		Event:     sql.NullString{String: query.Get("event"), Valid: query.Get("event") != ""},
+		Outcome:   sql.NullString{String: query.Get("outcome"), Valid: query.Get("outcome") != ""},
+		RowLimit:  limit,
+		RowOffset: offset,
+	}
+
+	for name, dest := range map[string]*uuid.NullUUID{"actor_id": &eventsParams.ActorID, "subject_id": &eventsParams.SubjectID} {
+		if query.Get(name) == "" {
+			continue
+		}
+		dest.UUID, err = uuid.Parse(query.Get(name))
+		if err != nil {
+			log.Printf("Invalid %s: %s", name, err)
+			w.WriteHeader(400)
+			return
+		}
+		dest.Valid = true
+	}
+
+	for name, dest := range map[string]*sql.NullTime{"since": &eventsParams.Since, "until": &eventsParams.Until} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			log.Printf("Invalid %s: %s", name, err)
+			w.WriteHeader(400)
+			return
+		}
+		dest.Time, dest.Valid = parsed.UTC(), true
+	}
+
+	events, err := cfg.Db.GetAuditEvents(r.Context(), eventsParams)
+	if err != nil {
+		log.Printf("Error getting audit events: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type returnVals struct {
+		ID        uuid.UUID  `json:"id"`
+		CreatedAt time.Time  `json:"created_at"`
+		Event     string     `json:"event"`
+		Outcome   string     `json:"outcome"`
+		ActorID   *uuid.UUID `json:"actor_id"`
+		SubjectID *uuid.UUID `json:"subject_id"`
+		IP        string     `json:"ip"`
+		UserAgent string     `json:"user_agent"`
+		Details   string     `json:"details"`
+	}
+
+	respStruct := make([]returnVals, 0, len(events))
+	for _, event := range events {
+		vals := returnVals{
+			ID:        event.ID,
+			CreatedAt: event.CreatedAt.UTC(),
+			Event:     event.Event,
+			Outcome:   event.Outcome,
+			IP:        event.Ip,
+			UserAgent: event.UserAgent,
+			Details:   event.Details,
+		}
+		if event.ActorID.Valid {
+			vals.ActorID = &event.ActorID.UUID
+		}
+		if event.SubjectID.Valid {
+			vals.SubjectID = &event.SubjectID.UUID
+		}
+		respStruct = append(respStruct, vals)
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type testAuditEvent struct {
+	ID        uuid.UUID  `json:"id"`
+	Event     string     `json:"event"`
+	Outcome   string     `json:"outcome"`
+	ActorID   *uuid.UUID `json:"actor_id"`
+	SubjectID *uuid.UUID `json:"subject_id"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func getAuditEvents(t *testing.T, cfg *ApiConfig, query url.Values) []testAuditEvent {
+	t.Helper()
+
+	rec := doJSON(t, http.HandlerFunc(cfg.AuditEventsHandler), "GET", "/admin/audit?"+query.Encode(), "", nil)
+	if rec.Code != 200 {
+		t.Fatalf("Audit events: expected 200, got %d", rec.Code)
+	}
+
+	events := []testAuditEvent{}
+	decodeJSON(t, rec, &events)
+	return events
+}
+
+func TestAuditEventsTimeFilterOffsets(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+
+	before := time.Now().Add(-time.Minute)
+	registerAndLogin(t, cfg, "offset@example.com", "offset-password")
+
+	// The same instant written two hours ahead of UTC
+	cest := before.In(time.FixedZone("CEST", 2*60*60)).Format(time.RFC3339)
+
+	events := getAuditEvents(t, cfg, url.Values{"event": {auditLogin}, "since": {cest}})
+	if len(events) != 1 {
+		t.Fatalf("Expected the login since %s, got %d events", cest, len(events))
+	}
+
+	events = getAuditEvents(t, cfg, url.Values{"event": {auditLogin}, "until": {cest}})
+	if len(events) != 0 {
+		t.Fatalf("Expected no logins until %s, got %d events", cest, len(events))
+	}
+}
+
+func TestAuditEventsFilters(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+
+	alice := registerAndLogin(t, cfg, "alice@example.com", "alice-password")
+	bob := registerAndLogin(t, cfg, "bob@example.com", "bob-password")
+	loginTestUser(t, cfg, "alice@example.com", "alice-password")
+
+	rec := doJSON(t, http.HandlerFunc(cfg.LoginHandler), "POST", "/api/login", "", map[string]string{"email": "bob@example.com", "password": "wrong-password"})
+	if rec.Code != 401 {
+		t.Fatalf("Login with the wrong password: expected 401, got %d", rec.Code)
+	}
+
+	cases := []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"logins", url.Values{"event": {auditLogin}}, 4},
+		{"failed logins", url.Values{"event": {auditLogin}, "outcome": {auditFailure}}, 1},
+		{"logins by actor", url.Values{"event": {auditLogin}, "actor_id": {alice.UserID.String()}}, 2},
+		{"logins by subject", url.Values{"event": {auditLogin}, "subject_id": {bob.UserID.String()}}, 2},
+		{"failed logins by subject", url.Values{"event": {auditLogin}, "outcome": {auditFailure}, "subject_id": {alice.UserID.String()}}, 0},
+		{"limited", url.Values{"event": {auditLogin}, "limit": {"3"}}, 3},
+		{"offset", url.Values{"event": {auditLogin}, "limit": {"3"}, "offset": {"3"}}, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			events := getAuditEvents(t, cfg, c.query)
+			if len(events) != c.want {
+				t.Fatalf("Expected %d events, got %d", c.want, len(events))
+			}
+			for _, event := range events {
+				if event.Event != auditLogin {
+					t.Fatalf("Expected only %s events, got %s", auditLogin, event.Event)
+				}
+			}
+		})
+	}
+
+	// Pages follow on from each other, newest first
+	first := getAuditEvents(t, cfg, url.Values{"event": {auditLogin}, "limit": {"3"}})
+	rest := getAuditEvents(t, cfg, url.Values{"event": {auditLogin}, "limit": {"3"}, "offset": {"3"}})
+	all := append(first, rest...)
+	for i := 1; i < len(all); i++ {
+		if all[i].ID == all[i-1].ID || all[i].CreatedAt.After(all[i-1].CreatedAt) {
+			t.Fatalf("Pages are not in order, newest first: %+v", all)
+		}
+	}
+
+	for _, query := range []url.Values{
+		{"actor_id": {"not-a-uuid"}},
+		{"since": {"yesterday"}},
+		{"limit": {"0"}},
+		{"offset": {"-1"}},
+	} {
+		rec := doJSON(t, http.HandlerFunc(cfg.AuditEventsHandler), "GET", "/admin/audit?"+query.Encode(), "", nil)
+		if rec.Code != 400 {
+			t.Fatalf("Filter %v: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
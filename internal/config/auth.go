@@ -3,7 +3,11 @@ package config
 import (
 	"chirpy/internal/auth"
 	"chirpy/internal/database"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -13,9 +17,11 @@ import (
 
 func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		Expires  int    `json:"expires_in_seconds"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		Expires     int    `json:"expires_in_seconds"`
+		DeviceLabel string `json:"device_label"`
+		UseCookies  bool   `json:"use_cookies"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -27,19 +33,24 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.Db.GetUser(r.Context(), params.Email)
-	if err != nil {
-		log.Printf("User not found: %s", err)
-		w.WriteHeader(401)
+	user, remaining, err := cfg.checkPassword(r.Context(), params.Email, params.Password, cfg.clientIP(r))
+	if errors.Is(err, errLockedOut) {
+		log.Printf("Login attempt while locked out")
+		cfg.audit(r, auditEntry{Event: auditLogin, Outcome: auditDenied, Details: "locked out: " + params.Email})
+		respondLockedOut(w, remaining)
 		return
 	}
-
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
-	if err != nil {
+	if errors.Is(err, errBadCredentials) {
 		log.Printf("Wrong login or password: %s", err)
+		cfg.audit(r, auditEntry{Event: auditLogin, Outcome: auditFailure, SubjectID: user.ID, Details: "wrong email or password: " + params.Email})
 		w.WriteHeader(401)
 		return
 	}
+	if err != nil {
+		log.Printf("Error checking password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
 
 	expiresIn := 0
 
@@ -49,7 +60,83 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		expiresIn = params.Expires
 	}
 
-	token, err := auth.MakeJWT(user.ID, cfg.TokenSecret, time.Duration(expiresIn)*time.Second)
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user, loginOptions{
+		ExpiresIn:   time.Duration(expiresIn) * time.Second,
+		DeviceLabel: params.DeviceLabel,
+		UseCookies:  params.UseCookies,
+	})
+}
+
+var (
+	errLockedOut      = errors.New("too many failed logins")
+	errBadCredentials = errors.New("wrong email or password")
+)
+
+// checkPassword is the lockout-aware password check behind every password
+// form. When the login is locked out it also returns how long is left. On a
+// wrong password for an existing account the user is returned along with
+// errBadCredentials, for auditing only.
+func (cfg *ApiConfig) checkPassword(ctx context.Context, email, password, ip string) (database.User, time.Duration, error) {
+	remaining, err := cfg.loginLockedOut(ctx, accountLockoutKey(email), ipLockoutKey(ip))
+	if err != nil {
+		return database.User{}, 0, err
+	}
+	if remaining > 0 {
+		return database.User{}, remaining, errLockedOut
+	}
+
+	user, err := cfg.Db.GetUser(ctx, email)
+	if err != nil {
+		cfg.Passwords.Verify(password, cfg.dummyPasswordHash())
+		cfg.recordLoginFailure(ctx, email, ip)
+		return database.User{}, 0, fmt.Errorf("%w: %w", errBadCredentials, err)
+	}
+
+	err = cfg.Passwords.Verify(password, user.HashedPassword)
+	if err != nil {
+		cfg.recordLoginFailure(ctx, email, ip)
+		return user, 0, fmt.Errorf("%w: %w", errBadCredentials, err)
+	}
+
+	// With MFA on, the password is only half the login. Keep counting
+	// failures until the second factor passes as well.
+	if !user.TotpEnabledAt.Valid {
+		cfg.clearLoginFailures(ctx, email)
+	}
+
+	if cfg.Passwords.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(ctx, user.ID, password)
+	}
+
+	return user, 0, nil
+}
+
+type loginOptions struct {
+	ExpiresIn   time.Duration
+	DeviceLabel string
+	// UseCookies hands the tokens to a browser as HttpOnly cookies instead
+	// of in the response body.
+	UseCookies bool
+}
+
+// respondWithLogin issues a fresh access/refresh token pair for user. Every
+// way of logging in ends here so clients always get the same response shape.
+func (cfg *ApiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User, opts loginOptions) {
+	if user.SuspendedAt.Valid {
+		log.Printf("Login by suspended user %s", user.ID)
+		cfg.audit(r, auditEntry{Event: auditLogin, Outcome: auditDenied, ActorID: user.ID, SubjectID: user.ID, Details: "suspended"})
+		w.WriteHeader(403)
+		return
+	}
+
+	sessionID := uuid.New()
+
+	token, err := auth.MakeJWT(user.ID, cfg.Keys, opts.ExpiresIn, auth.WithSessionID(sessionID), auth.WithRole(user.Role))
 	if err != nil {
 		log.Printf("Error generating access token: %s", err)
 		w.WriteHeader(500)
@@ -64,35 +151,49 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	refreshTokenParams := database.CreateRefreshTokenParams{
-		Token:  rawRefreshToken,
-		UserID: user.ID,
+		TokenHash:   auth.HashToken(rawRefreshToken, cfg.TokenSecret),
+		TokenPrefix: auth.TokenPrefix(rawRefreshToken),
+		UserID:      user.ID,
+		FamilyID:    sessionID,
+		UserAgent:   r.UserAgent(),
+		Ip:          cfg.clientIP(r),
+		DeviceLabel: opts.DeviceLabel,
 	}
 
-	refreshToken, err := cfg.Db.CreateRefreshToken(r.Context(), refreshTokenParams)
+	_, err = cfg.Db.CreateRefreshToken(r.Context(), refreshTokenParams)
 	if err != nil {
 		log.Printf("Error inserting refresh token: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	cfg.audit(r, auditEntry{Event: auditLogin, Outcome: auditSuccess, ActorID: user.ID, SubjectID: user.ID, Details: "session " + sessionID.String()})
+
 	type returnVals struct {
 		ID           uuid.UUID `json:"id"`
 		Email        string    `json:"email"`
 		Created_at   time.Time `json:"created_at"`
 		Updated_at   time.Time `json:"updated_at"`
-		Token        string    `json:"token"`
-		RefreshToken string    `json:"refresh_token"`
+		Token        string    `json:"token,omitempty"`
+		RefreshToken string    `json:"refresh_token,omitempty"`
+		CSRFToken    string    `json:"csrf_token,omitempty"`
 		IsChirpyRed  bool      `json:"is_chirpy_red"`
 	}
 
 	respStruct := returnVals{
-		ID:           user.ID,
-		Email:        user.Email,
-		Created_at:   user.CreatedAt,
-		Updated_at:   user.UpdatedAt,
-		Token:        token,
-		RefreshToken: refreshToken.Token,
-		IsChirpyRed:  user.IsChirpyRed,
+		ID:          user.ID,
+		Email:       user.Email,
+		Created_at:  user.CreatedAt,
+		Updated_at:  user.UpdatedAt,
+		IsChirpyRed: cfg.isChirpyRed(r.Context(), user.ID),
+	}
+
+	if opts.UseCookies {
+		cfg.setSessionCookies(w, token, opts.ExpiresIn, rawRefreshToken, sessionID.String())
+		respStruct.CSRFToken = cfg.csrfToken(sessionID.String())
+	} else {
+		respStruct.Token = token
+		respStruct.RefreshToken = rawRefreshToken
 	}
 
 	dat, err := json.Marshal(respStruct)
@@ -105,36 +206,137 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	w.Write(dat)
+}
+
+// Failing to upgrade a hash is not a reason to fail the login; the next
+// successful login will try again.
+func (cfg *ApiConfig) rehashPassword(ctx context.Context, userID uuid.UUID, password string) {
+	hashedPass, err := cfg.Passwords.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing password: %s", err)
+		return
+	}
 
+	updateParams := database.UpdateUserPasswordParams{
+		HashedPassword: hashedPass,
+		ID:             userID,
+	}
+
+	err = cfg.Db.UpdateUserPassword(ctx, updateParams)
+	if err != nil {
+		log.Printf("Error storing rehashed password: %s", err)
+	}
 }
+
 func (cfg *ApiConfig) RefreshHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetToken(r.Header, "Bearer ")
+	token, fromCookie, err := requestToken(r, refreshTokenCookie)
+	if err != nil {
+		log.Printf("Error getting refresh token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	tokenHash := auth.HashToken(token, cfg.TokenSecret)
+
+	current, err := cfg.Db.GetRefreshToken(r.Context(), tokenHash)
 	if err != nil {
 		log.Printf("Error getting refresh token: %s", err)
 		w.WriteHeader(401)
 		return
 	}
 
-	user, err := cfg.Db.GetUserFromRefreshToken(r.Context(), token)
+	if fromCookie {
+		err = cfg.checkCSRF(r, current.FamilyID.String())
+		if err != nil {
+			log.Printf("Error refreshing session: %s", err)
+			w.WriteHeader(403)
+			return
+		}
+	}
+
+	if current.ReplacedBy.Valid {
+		cfg.revokeReusedRefreshToken(r, current)
+		w.WriteHeader(401)
+		return
+	}
+
+	rawRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		log.Printf("Error getting user by refresh token: %s", err)
+		log.Printf("Error generating refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	rotateParams := database.RotateRefreshTokenParams{
+		TokenHash:  tokenHash,
+		ReplacedBy: sql.NullString{String: auth.HashToken(rawRefreshToken, cfg.TokenSecret), Valid: true},
+	}
+
+	refreshTokenParams := database.CreateRefreshTokenParams{
+		TokenHash:   auth.HashToken(rawRefreshToken, cfg.TokenSecret),
+		TokenPrefix: auth.TokenPrefix(rawRefreshToken),
+		UserID:      current.UserID,
+		FamilyID:    current.FamilyID,
+		UserAgent:   r.UserAgent(),
+		Ip:          cfg.clientIP(r),
+		DeviceLabel: current.DeviceLabel,
+	}
+
+	// If the new token can't be stored the old one must stay usable, or the
+	// client's retry would look like reuse and end the session
+	err = cfg.inTx(r.Context(), func(q *database.Queries) error {
+		_, err := q.RotateRefreshToken(r.Context(), rotateParams)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.CreateRefreshToken(r.Context(), refreshTokenParams)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either revoked/expired, or a concurrent request rotated it first
+		rotated, lookupErr := cfg.Db.GetRefreshToken(r.Context(), tokenHash)
+		if lookupErr == nil && rotated.ReplacedBy.Valid {
+			cfg.revokeReusedRefreshToken(r, rotated)
+		}
+		log.Printf("Refresh token is no longer valid")
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), current.UserID)
+	if err != nil {
+		log.Printf("Error retrieving user: %s", err)
 		w.WriteHeader(401)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(user.UUID, cfg.TokenSecret, time.Duration(3600))
+	accessToken, err := auth.MakeJWT(user.ID, cfg.Keys, time.Hour, auth.WithSessionID(current.FamilyID), auth.WithRole(user.Role))
 	if err != nil {
 		log.Printf("Error generating access token: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	if fromCookie {
+		cfg.setSessionCookies(w, accessToken, time.Hour, rawRefreshToken, current.FamilyID.String())
+		w.WriteHeader(204)
+		return
+	}
+
 	type returnVals struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	respStruct := returnVals{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: rawRefreshToken,
 	}
 
 	dat, err := json.Marshal(respStruct)
@@ -149,20 +351,72 @@ func (cfg *ApiConfig) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(dat)
 }
 
+// A rotated refresh token should never be presented again. If it is, either
+// the client or an attacker holds a stale copy, so the whole family goes.
+func (cfg *ApiConfig) revokeReusedRefreshToken(r *http.Request, token database.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, family %s: possible token theft, revoking family", token.UserID, token.FamilyID)
+	cfg.audit(r, auditEntry{Event: auditTokenReuse, Outcome: auditDenied, SubjectID: token.UserID, Details: "session " + token.FamilyID.String()})
+
+	err := cfg.Db.RevokeRefreshTokenFamily(r.Context(), token.FamilyID)
+	if err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", token.FamilyID, err)
+	}
+}
+
 func (cfg *ApiConfig) RevokeHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetToken(r.Header, "Bearer ")
+	token, fromCookie, err := requestToken(r, refreshTokenCookie)
+	if err != nil {
+		log.Printf("Error getting refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tokenHash := auth.HashToken(token, cfg.TokenSecret)
+
+	current, err := cfg.Db.GetRefreshToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Unknown refresh token")
+		w.WriteHeader(401)
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting refresh token: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	_, err = cfg.Db.RevokeRefreshToken(r.Context(), token)
+	if fromCookie {
+		err = cfg.checkCSRF(r, current.FamilyID.String())
+		if err != nil {
+			log.Printf("Error revoking session: %s", err)
+			cfg.audit(r, auditEntry{Event: auditLogout, Outcome: auditDenied, ActorID: current.UserID, SubjectID: current.UserID, Details: "bad CSRF token"})
+			w.WriteHeader(403)
+			return
+		}
+
+		cfg.clearSessionCookies(w)
+	}
+
+	rows, err := cfg.Db.RevokeRefreshToken(r.Context(), tokenHash)
 	if err != nil {
 		log.Printf("Error revoking refresh token: %s", err)
 		w.WriteHeader(500)
 		return
 	}
+	if rows == 0 {
+		// Already revoked or rotated; logging out twice is still logged out
+		w.WriteHeader(204)
+		return
+	}
+
+	err = cfg.revokeAccessTokens(r.Context(), auth.RevokedSessionKey(current.FamilyID))
+	if err != nil {
+		log.Printf("Error revoking access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditLogout, Outcome: auditSuccess, ActorID: current.UserID, SubjectID: current.UserID, Details: "session " + current.FamilyID.String()})
 
 	w.WriteHeader(204)
 }
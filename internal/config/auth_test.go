@@ -0,0 +1,107 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRefreshRotatesToken(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	session := registerAndLogin(t, cfg, "refresh@example.com", "refresh-password")
+	refresh := http.HandlerFunc(cfg.RefreshHandler)
+
+	rec := doJSON(t, refresh, "POST", "/api/refresh", session.RefreshToken, nil)
+	if rec.Code != 200 {
+		t.Fatalf("Refresh: expected 200, got %d", rec.Code)
+	}
+
+	refreshed := testSession{}
+	decodeJSON(t, rec, &refreshed)
+	if refreshed.Token == "" || refreshed.RefreshToken == "" || refreshed.RefreshToken == session.RefreshToken {
+		t.Fatalf("Refresh did not issue a new token pair")
+	}
+
+	rec = doJSON(t, refresh, "POST", "/api/refresh", refreshed.RefreshToken, nil)
+	if rec.Code != 200 {
+		t.Fatalf("Refresh with the rotated token: expected 200, got %d", rec.Code)
+	}
+
+	latest := testSession{}
+	decodeJSON(t, rec, &latest)
+
+	// Presenting a rotated token again ends the whole session
+	rec = doJSON(t, refresh, "POST", "/api/refresh", session.RefreshToken, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Reused refresh token: expected 401, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, refresh, "POST", "/api/refresh", latest.RefreshToken, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Refresh token from a reused family: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	session := registerAndLogin(t, cfg, "revoke@example.com", "revoke-password")
+	revoke := http.HandlerFunc(cfg.RevokeHandler)
+
+	rec := doJSON(t, revoke, "POST", "/api/revoke", "not-a-refresh-token", nil)
+	if rec.Code != 401 {
+		t.Fatalf("Revoking an unknown token: expected 401, got %d", rec.Code)
+	}
+
+	for range 2 {
+		rec = doJSON(t, revoke, "POST", "/api/revoke", session.RefreshToken, nil)
+		if rec.Code != 204 {
+			t.Fatalf("Revoke: expected 204, got %d", rec.Code)
+		}
+	}
+
+	rec = doJSON(t, http.HandlerFunc(cfg.RefreshHandler), "POST", "/api/refresh", session.RefreshToken, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Refresh with a revoked token: expected 401, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, http.HandlerFunc(cfg.ChirpsHandler), "POST", "/api/chirps", session.Token, map[string]string{"body": "still here?"})
+	if rec.Code != 401 {
+		t.Fatalf("Access token of a revoked session: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestLegacyRefreshTokensAreHashed(t *testing.T) {
+	cfg, _, db := newTestConfig(t)
+	session := registerAndLogin(t, cfg, "legacy@example.com", "legacy-password")
+
+	// A token as stored before hashing: raw, with an empty prefix
+	rawToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = db.Exec(`INSERT INTO refresh_tokens (token_hash, token_prefix, created_at, updated_at, expires_at, user_id, family_id, last_used_at)
+		VALUES ($1, '', NOW(), NOW(), NOW() + INTERVAL '60 days', $2, gen_random_uuid (), NOW())`, rawToken, session.UserID)
+	if err != nil {
+		t.Fatalf("Error storing legacy token: %s", err)
+	}
+
+	err = cfg.HashLegacyRefreshTokens(context.Background())
+	if err != nil {
+		t.Fatalf("Error hashing legacy tokens: %s", err)
+	}
+
+	var stored string
+	err = db.QueryRow("SELECT token_hash FROM refresh_tokens WHERE token_prefix = $1", auth.TokenPrefix(rawToken)).Scan(&stored)
+	if err != nil {
+		t.Fatalf("Error reading hashed token: %s", err)
+	}
+	if stored != auth.HashToken(rawToken, cfg.TokenSecret) {
+		t.Fatalf("Expected the legacy token to be stored hashed")
+	}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.RefreshHandler), "POST", "/api/refresh", rawToken, nil)
+	if rec.Code != 200 {
+		t.Fatalf("Refresh with a legacy token: expected 200, got %d", rec.Code)
+	}
+}
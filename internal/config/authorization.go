@@ -0,0 +1,233 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+type principalKey struct{}
+
+// RequireRole only lets through requests made with a user's own access token
+// whose role is at least role. The caller is available to next through
+// principalFromContext.
+func (cfg *ApiConfig) RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := cfg.authenticate(r, "")
+		if err != nil {
+			log.Printf("Error authenticating request: %s", err)
+			w.WriteHeader(authErrorStatus(err))
+			return
+		}
+
+		if !auth.HasRole(caller.Role, role) {
+			log.Printf("User %s with role %q denied %s %s", caller.UserID, caller.Role, r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, caller)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func principalFromContext(ctx context.Context) (principal, bool) {
+	caller, ok := ctx.Value(principalKey{}).(principal)
+	return caller, ok
+}
+
+func (cfg *ApiConfig) SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if !auth.ValidRole(params.Role) {
+		log.Printf("Invalid role: %q", params.Role)
+		w.WriteHeader(400)
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("Invalid user ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	if user.Role != params.Role {
+		setParams := database.SetUserRoleParams{
+			Role: params.Role,
+			ID:   userID,
+		}
+
+		user, err = cfg.Db.SetUserRole(r.Context(), setParams)
+		if err != nil {
+			log.Printf("Error setting user role: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		// The role is in every access token, so the old ones must go
+		err = cfg.revokeUserTokens(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error revoking tokens after role change: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	cfg.audit(r, auditEntry{Event: auditRoleChange, Outcome: auditSuccess, ActorID: adminActor(r), SubjectID: user.ID, Details: "role " + user.Role})
+
+	type returnVals struct {
+		ID    uuid.UUID `json:"id"`
+		Email string    `json:"email"`
+		Role  string    `json:"role"`
+	}
+
+	respStruct := returnVals{
+		ID:    user.ID,
+		Email: user.Email,
+		Role:  user.Role,
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+// BootstrapAdmin promotes the user registered with email to admin, so that a
+// fresh install has someone who can manage roles through the API. A missing
+// user is not an error: register the account and restart the server.
+func (cfg *ApiConfig) BootstrapAdmin(ctx context.Context, email string) error {
+	user, err := cfg.Db.GetUser(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Admin %s is not registered yet", email)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Role == auth.RoleAdmin {
+		return nil
+	}
+
+	setParams := database.SetUserRoleParams{
+		Role: auth.RoleAdmin,
+		ID:   user.ID,
+	}
+
+	_, err = cfg.Db.SetUserRole(ctx, setParams)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.revokeUserTokens(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	eventParams := database.CreateAuditEventParams{
+		Event:     auditRoleChange,
+		Outcome:   auditSuccess,
+		SubjectID: uuid.NullUUID{UUID: user.ID, Valid: true},
+		Details:   "role " + auth.RoleAdmin + " from ADMIN_EMAIL",
+	}
+
+	err = cfg.Db.CreateAuditEvent(ctx, eventParams)
+	if err != nil {
+		log.Printf("Error writing audit event %s: %s", auditRoleChange, err)
+	}
+
+	log.Printf("Promoted %s to admin", email)
+	return nil
+}
+
+// Suspending a user logs them out everywhere and stops them logging back in
+// until they are unsuspended.
+func (cfg *ApiConfig) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("Invalid user ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	rows, err := cfg.Db.SuspendUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error suspending user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		log.Printf("User %s not found or already suspended", userID)
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.revokeUserTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditSuspend, Outcome: auditSuccess, ActorID: adminActor(r), SubjectID: userID})
+
+	w.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("Invalid user ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	rows, err := cfg.Db.UnsuspendUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error unsuspending user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		log.Printf("User %s not found or not suspended", userID)
+		w.WriteHeader(404)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditUnsuspend, Outcome: auditSuccess, ActorID: adminActor(r), SubjectID: userID})
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestDemotedAdminTokenIsRejected(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+
+	registerAndLogin(t, cfg, "root@example.com", "root-password")
+	err := cfg.BootstrapAdmin(context.Background(), "root@example.com")
+	if err != nil {
+		t.Fatalf("Error promoting admin: %s", err)
+	}
+
+	root := loginTestUser(t, cfg, "root@example.com", "root-password")
+	moderator := registerAndLogin(t, cfg, "moderator@example.com", "moderator-password")
+
+	mux := http.NewServeMux()
+	mux.Handle("PUT /admin/users/{userID}/role", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.SetUserRoleHandler)))
+	mux.Handle("GET /admin/check", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	})))
+
+	setRole := func(role string) {
+		t.Helper()
+
+		rec := doJSON(t, mux, "PUT", "/admin/users/"+moderator.UserID.String()+"/role", root.Token, map[string]string{"role": role})
+		if rec.Code != 200 {
+			t.Fatalf("Setting role %s: expected 200, got %d", role, rec.Code)
+		}
+	}
+
+	setRole(auth.RoleAdmin)
+	promoted := loginTestUser(t, cfg, "moderator@example.com", "moderator-password")
+
+	rec := doJSON(t, mux, "GET", "/admin/check", promoted.Token, nil)
+	if rec.Code != 204 {
+		t.Fatalf("Admin check: expected 204, got %d", rec.Code)
+	}
+
+	setRole(auth.RoleUser)
+
+	rec = doJSON(t, mux, "GET", "/admin/check", promoted.Token, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Demoted admin's old token: expected 401, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, http.HandlerFunc(cfg.RefreshHandler), "POST", "/api/refresh", promoted.RefreshToken, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Demoted admin's old refresh token: expected 401, got %d", rec.Code)
+	}
+}
@@ -3,15 +3,28 @@ package config
 import (
 	"chirpy/internal/auth"
 	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"chirpy/internal/pagination"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	defaultChirpPageSize = 50
+	maxChirpPageSize     = 100
+)
+
 func (cfg *ApiConfig) ChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Body string    `json:"body"`
@@ -27,23 +40,25 @@ func (cfg *ApiConfig) ChirpsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.GetToken(r.Header, "Bearer ")
+	caller, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		log.Printf("error parsing token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
 		return
 	}
 
-	user, err := auth.ValidateJWT(token, cfg.TokenSecret)
+	ents := cfg.entitlementsFor(r.Context(), caller.UserID)
+
+	err = cfg.checkCanChirp(r.Context(), caller.UserID, ents)
 	if err != nil {
-		log.Printf("invalid token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("User %s can't chirp: %s", caller.UserID, err)
+		respondCantChirp(w, err)
 		return
 	}
 
 	newChirp := database.CreateChirpParams{}
 
-	if len(params.Body) <= 140 {
+	if len(params.Body) <= ents.MaxChirpLength {
 		newChirp.Body = cleanChirp(params.Body)
 	} else {
 		w.Header().Set("Content-Type", "application/json")
@@ -51,7 +66,7 @@ func (cfg *ApiConfig) ChirpsHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Chirp Too Long"))
 		return
 	}
-	newChirp.UserID = user
+	newChirp.UserID = caller.UserID
 
 	enteredChirp, err := cfg.Db.CreateChirp(r.Context(), newChirp)
 	if err != nil {
@@ -72,33 +87,143 @@ func (cfg *ApiConfig) ChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(dat)
 }
 
-func (cfg *ApiConfig) GetChirpsHandler(w http.ResponseWriter, r *http.Request) {
+var (
+	errEmailNotVerified = errors.New("email address is not verified")
+	errChirpLimit       = errors.New("hourly chirp limit reached")
+	errNotChirpAuthor   = errors.New("not the chirp's author")
+	errEditWindowClosed = errors.New("edit window has closed")
+)
+
+// checkCanChirp applies the rules for writing a chirp body, whether new or
+// an edit: a verified email address if the server requires one, and the
+// plan's hourly limit, which edits count towards as well as new chirps.
+func (cfg *ApiConfig) checkCanChirp(ctx context.Context, userID uuid.UUID, ents entitlements.Entitlements) error {
+	if cfg.RequireVerifiedEmail {
+		author, err := cfg.Db.GetUserByID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("error retrieving user: %w", err)
+		}
+
+		if !author.EmailVerifiedAt.Valid {
+			return errEmailNotVerified
+		}
+	}
 
-	author := r.URL.Query().Get("author_id")
-	sortMode := r.URL.Query().Get("sort")
+	chirps, err := cfg.Db.CountChirpsInLastHour(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error counting recent chirps: %w", err)
+	}
+
+	edits, err := cfg.Db.CountChirpEditsInLastHour(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error counting recent edits: %w", err)
+	}
 
-	params := database.GetChirpsParams{}
+	if chirps+edits >= int64(ents.ChirpsPerHour) {
+		return fmt.Errorf("%w: the %s plan allows %d an hour", errChirpLimit, ents.Plan, ents.ChirpsPerHour)
+	}
+
+	return nil
+}
+
+func respondCantChirp(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEmailNotVerified):
+		w.WriteHeader(403)
+	case errors.Is(err, errChirpLimit):
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(429)
+	default:
+		w.WriteHeader(500)
+	}
+}
+
+// checkCanEdit reports whether userID may still edit chirp at now: only its
+// author can, and only within window of posting it.
+func checkCanEdit(chirp database.Chirp, userID uuid.UUID, window time.Duration, now time.Time) error {
+	if chirp.UserID != userID {
+		return errNotChirpAuthor
+	}
+	if now.Sub(chirp.CreatedAt) > window {
+		return errEditWindowClosed
+	}
+	return nil
+}
+
+// GetChirpsHandler returns one page of chirps, oldest first unless
+// sort=desc, optionally only those by author_id. The body stays a plain array
+// of chirps; the neighbouring pages are linked from the Link header.
+func (cfg *ApiConfig) GetChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	author := query.Get("author_id")
+	sortMode := query.Get("sort")
+
+	params := database.GetChirpsAscParams{}
 
 	if author != "" {
-		params.UserID = uuid.MustParse(author)
+		authorID, err := uuid.Parse(author)
+		if err != nil {
+			log.Printf("Invalid author ID: %s", err)
+			w.WriteHeader(400)
+			return
+		}
+		params.UserID = authorID
 		params.Skip = false
 	} else {
 		params.Skip = true
 	}
 
-	if sortMode == "desc" {
-		params.OrderBy = "desc"
-	} else {
-		params.OrderBy = "asc"
+	limit, err := parseLimit(query, defaultChirpPageSize, maxChirpPageSize)
+	if err != nil {
+		log.Printf("Invalid limit: %s", err)
+		w.WriteHeader(400)
+		return
 	}
 
-	chirps, err := cfg.Db.GetChirps(r.Context(), params)
+	cursor := pagination.Cursor{}
+	if query.Get("cursor") != "" {
+		cursor, err = pagination.DecodeCursor(query.Get("cursor"))
+		if err != nil {
+			log.Printf("Invalid cursor: %s", err)
+			w.WriteHeader(400)
+			return
+		}
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	// One extra row tells us whether there is another page after this one
+	params.RowLimit = limit + 1
+
+	// Going back a page means reading the other way from the cursor
+	var chirps []database.Chirp
+	if (sortMode == "desc") != cursor.Backward {
+		chirps, err = cfg.Db.GetChirpsDesc(r.Context(), database.GetChirpsDescParams(params))
+	} else {
+		chirps, err = cfg.Db.GetChirpsAsc(r.Context(), params)
+	}
 	if err != nil {
 		log.Printf("Error retrieving chirps: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	more := len(chirps) > int(limit)
+	if more {
+		chirps = chirps[:limit]
+	}
+	if cursor.Backward {
+		slices.Reverse(chirps)
+	}
+	if chirps == nil {
+		chirps = []database.Chirp{}
+	}
+
+	link := chirpPageLinks(r.URL, chirps, cursor, query.Get("cursor") != "", more)
+	if link != "" {
+		w.Header().Set("Link", link)
+	}
+
 	dat, err := json.Marshal(chirps)
 	if err != nil {
 		log.Printf("Error marshalling JSON: %s", err)
@@ -111,9 +236,46 @@ func (cfg *ApiConfig) GetChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(dat)
 }
 
+// chirpPageLinks builds the Link header for the pages either side of chirps,
+// a page in display order read from cursor. fromCursor is false on the first
+// page, and more reports whether the read found rows past the end of the
+// page. Each link points back at requestURL with the page's cursor.
+func chirpPageLinks(requestURL *url.URL, chirps []database.Chirp, cursor pagination.Cursor, fromCursor, more bool) string {
+	if len(chirps) == 0 {
+		return ""
+	}
+
+	pageLink := func(pageCursor pagination.Cursor, rel string) string {
+		pageQuery := requestURL.Query()
+		pageQuery.Set("cursor", pageCursor.Encode())
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, requestURL.Path, pageQuery.Encode(), rel)
+	}
+
+	first, last := chirps[0], chirps[len(chirps)-1]
+	var links []string
+
+	// Whichever way we came from a cursor, there is a page on that side
+	if more || cursor.Backward {
+		nextCursor := pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		links = append(links, pageLink(nextCursor, "next"))
+	}
+	if (more && cursor.Backward) || (fromCursor && !cursor.Backward) {
+		prevCursor := pagination.Cursor{CreatedAt: first.CreatedAt, ID: first.ID, Backward: true}
+		links = append(links, pageLink(prevCursor, "prev"))
+	}
+
+	return strings.Join(links, ", ")
+}
+
 func (cfg *ApiConfig) GetChirpHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Invalid chirp ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
 
-	chirp, err := cfg.Db.GetChirp(r.Context(), uuid.MustParse(r.PathValue("chirpID")))
+	chirp, err := cfg.Db.GetChirp(r.Context(), chirpID)
 	if err != nil {
 		log.Printf("Error retrieving chirp: %s", err)
 		w.WriteHeader(404)
@@ -134,34 +296,35 @@ func (cfg *ApiConfig) GetChirpHandler(w http.ResponseWriter, r *http.Request) {
 
 func (cfg *ApiConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
 
-	token, err := auth.GetToken(r.Header, "Bearer ")
+	caller, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		log.Printf("Error getting access token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
 		return
 	}
 
-	user, err := auth.ValidateJWT(token, cfg.TokenSecret)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		log.Printf("Error validating access token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("Invalid chirp ID: %s", err)
+		w.WriteHeader(404)
 		return
 	}
 
-	chirp, err := cfg.Db.GetChirp(r.Context(), uuid.MustParse(r.PathValue("chirpID")))
+	chirp, err := cfg.Db.GetChirp(r.Context(), chirpID)
 	if err != nil {
 		log.Printf("Error retrieving chirp: %s", err)
 		w.WriteHeader(404)
 		return
 	}
 
-	if chirp.UserID != user {
-		log.Printf("Requestor not owner of tweet: %s", err)
+	if chirp.UserID != caller.UserID && !auth.HasRole(caller.Role, auth.RoleModerator) {
+		log.Printf("Requestor %s not owner of tweet %s", caller.UserID, chirp.ID)
 		w.WriteHeader(403)
+		return
 	}
 
 	deleteParams := database.DeleteChirpParams{
-		UserID: user,
+		UserID: chirp.UserID,
 		ID:     chirp.ID,
 	}
 	err = cfg.Db.DeleteChirp(r.Context(), deleteParams)
@@ -174,6 +337,139 @@ func (cfg *ApiConfig) DeleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(204)
 }
 
+// EditChirpHandler lets the author change a chirp within their plan's edit
+// window. The previous body is kept as a revision.
+func (cfg *ApiConfig) EditChirpHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	caller, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Invalid chirp ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	chirp, err := cfg.Db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error retrieving chirp: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	ents := cfg.entitlementsFor(r.Context(), caller.UserID)
+
+	err = checkCanEdit(chirp, caller.UserID, ents.EditWindow, time.Now())
+	if err != nil {
+		log.Printf("User %s can't edit chirp %s: %s", caller.UserID, chirp.ID, err)
+		w.WriteHeader(403)
+		return
+	}
+
+	err = cfg.checkCanChirp(r.Context(), caller.UserID, ents)
+	if err != nil {
+		log.Printf("User %s can't chirp: %s", caller.UserID, err)
+		respondCantChirp(w, err)
+		return
+	}
+
+	if len(params.Body) > ents.MaxChirpLength {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		w.Write([]byte("Chirp Too Long"))
+		return
+	}
+
+	editParams := database.EditChirpParams{
+		ID:   chirp.ID,
+		Body: cleanChirp(params.Body),
+	}
+
+	if editParams.Body != chirp.Body {
+		chirp, err = cfg.Db.EditChirp(r.Context(), editParams)
+		// Either another chirp already says this, or a concurrent edit
+		// took the same revision number
+		if isUniqueViolation(err) {
+			log.Printf("Edit of chirp %s conflicts: %s", editParams.ID, err)
+			w.WriteHeader(409)
+			return
+		}
+		if err != nil {
+			log.Printf("Error editing chirp: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	dat, err := json.Marshal(chirp)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+// ChirpRevisionsHandler lists the earlier bodies of a chirp, oldest first.
+// The current body is the chirp itself.
+func (cfg *ApiConfig) ChirpRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Invalid chirp ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	_, err = cfg.Db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error retrieving chirp: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	revisions, err := cfg.Db.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error retrieving chirp revisions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if revisions == nil {
+		revisions = []database.ChirpRevision{}
+	}
+
+	dat, err := json.Marshal(revisions)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
 func cleanChirp(chirp string) string {
 
 	naughtyWords := []string{
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"chirpy/internal/pagination"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckCanEdit(t *testing.T) {
+	author := uuid.New()
+	posted := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	chirp := database.Chirp{ID: uuid.New(), UserID: author, CreatedAt: posted}
+
+	cases := []struct {
+		name    string
+		userID  uuid.UUID
+		now     time.Time
+		wantErr error
+	}{
+		{"author inside the window", author, posted.Add(time.Minute), nil},
+		{"author at the end of the window", author, posted.Add(5 * time.Minute), nil},
+		{"author after the window", author, posted.Add(5*time.Minute + time.Second), errEditWindowClosed},
+		{"someone else inside the window", uuid.New(), posted.Add(time.Minute), errNotChirpAuthor},
+		{"someone else after the window", uuid.New(), posted.Add(time.Hour), errNotChirpAuthor},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkCanEdit(chirp, c.userID, 5*time.Minute, c.now)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("Expected %v, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEditChirp(t *testing.T) {
+	cfg, _, db := newTestConfig(t)
+
+	catalog := entitlements.DefaultCatalog()
+	free := catalog[entitlements.PlanFree]
+	free.ChirpsPerHour = 3
+	catalog[entitlements.PlanFree] = free
+	cfg.Entitlements = catalog
+
+	author := registerAndLogin(t, cfg, "author@example.com", "author-password")
+	other := registerAndLogin(t, cfg, "other@example.com", "other-password")
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/chirps", http.HandlerFunc(cfg.ChirpsHandler))
+	mux.Handle("PATCH /api/chirps/{chirpID}", http.HandlerFunc(cfg.EditChirpHandler))
+
+	rec := doJSON(t, mux, "POST", "/api/chirps", author.Token, map[string]string{"body": "first"})
+	if rec.Code != 201 {
+		t.Fatalf("Posting chirp: expected 201, got %d", rec.Code)
+	}
+
+	chirp := database.Chirp{}
+	decodeJSON(t, rec, &chirp)
+	target := "/api/chirps/" + chirp.ID.String()
+
+	rec = doJSON(t, mux, "PATCH", target, other.Token, map[string]string{"body": "not yours"})
+	if rec.Code != 403 {
+		t.Fatalf("Edit by someone else: expected 403, got %d", rec.Code)
+	}
+
+	for _, body := range []string{"second", "third"} {
+		rec = doJSON(t, mux, "PATCH", target, author.Token, map[string]string{"body": body})
+		if rec.Code != 200 {
+			t.Fatalf("Edit to %q: expected 200, got %d", body, rec.Code)
+		}
+	}
+
+	decodeJSON(t, rec, &chirp)
+	if chirp.Body != "third" || chirp.RevisionCount != 2 {
+		t.Fatalf("Expected body third with 2 revisions, got %q with %d", chirp.Body, chirp.RevisionCount)
+	}
+
+	// The chirp and both edits use up the hourly limit of 3
+	rec = doJSON(t, mux, "PATCH", target, author.Token, map[string]string{"body": "fourth"})
+	if rec.Code != 429 {
+		t.Fatalf("Edit over the hourly limit: expected 429, got %d", rec.Code)
+	}
+
+	_, err := db.Exec("UPDATE chirps SET created_at = NOW() - INTERVAL '10 minutes' WHERE id = $1", chirp.ID)
+	if err != nil {
+		t.Fatalf("Error backdating chirp: %s", err)
+	}
+
+	rec = doJSON(t, mux, "PATCH", target, author.Token, map[string]string{"body": "too late"})
+	if rec.Code != 403 {
+		t.Fatalf("Edit after the window: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestChirpPageLinks(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	chirps := []database.Chirp{}
+	for i := range 3 {
+		chirps = append(chirps, database.Chirp{ID: uuid.New(), CreatedAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+	first, last := chirps[0], chirps[len(chirps)-1]
+
+	nextCursor := pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	prevCursor := pagination.Cursor{CreatedAt: first.CreatedAt, ID: first.ID, Backward: true}
+	forward := pagination.Cursor{CreatedAt: start.Add(-time.Minute), ID: uuid.New()}
+	backward := pagination.Cursor{CreatedAt: start.Add(time.Hour), ID: uuid.New(), Backward: true}
+
+	cases := []struct {
+		name       string
+		chirps     []database.Chirp
+		cursor     pagination.Cursor
+		fromCursor bool
+		more       bool
+		wantNext   bool
+		wantPrev   bool
+	}{
+		{"first page with more", chirps, pagination.Cursor{}, false, true, true, false},
+		{"only page", chirps, pagination.Cursor{}, false, false, false, false},
+		{"forward with more", chirps, forward, true, true, true, true},
+		{"forward to the last page", chirps, forward, true, false, false, true},
+		{"backward with more", chirps, backward, true, true, true, true},
+		{"backward to the first page", chirps, backward, true, false, true, false},
+		{"empty page", nil, forward, true, false, false, false},
+	}
+
+	requestURL, err := url.Parse("/api/chirps?sort=desc&limit=3&cursor=old")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			link := chirpPageLinks(requestURL, c.chirps, c.cursor, c.fromCursor, c.more)
+
+			wantLinks := []string{}
+			if c.wantNext {
+				wantLinks = append(wantLinks, testPageLink(nextCursor.Encode(), "next"))
+			}
+			if c.wantPrev {
+				wantLinks = append(wantLinks, testPageLink(prevCursor.Encode(), "prev"))
+			}
+
+			if link != strings.Join(wantLinks, ", ") {
+				t.Fatalf("Expected Link %q, got %q", strings.Join(wantLinks, ", "), link)
+			}
+		})
+	}
+}
+
+// testPageLink is the Link header entry for a page of the request in
+// TestChirpPageLinks, with the other query parameters kept.
+func testPageLink(cursor, rel string) string {
+	query := url.Values{"sort": {"desc"}, "limit": {"3"}, "cursor": {cursor}}
+	return fmt.Sprintf(`</api/chirps?%s>; rel="%s"`, query.Encode(), rel)
+}
+
+func TestEditChirpConflict(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	author := registerAndLogin(t, cfg, "conflict@example.com", "conflict-password")
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/chirps", http.HandlerFunc(cfg.ChirpsHandler))
+	mux.Handle("PATCH /api/chirps/{chirpID}", http.HandlerFunc(cfg.EditChirpHandler))
+	mux.Handle("DELETE /api/chirps/{chirpID}", http.HandlerFunc(cfg.DeleteChirpHandler))
+
+	chirps := []database.Chirp{}
+	for _, body := range []string{"taken", "free"} {
+		rec := doJSON(t, mux, "POST", "/api/chirps", author.Token, map[string]string{"body": body})
+		if rec.Code != 201 {
+			t.Fatalf("Posting chirp: expected 201, got %d", rec.Code)
+		}
+		chirp := database.Chirp{}
+		decodeJSON(t, rec, &chirp)
+		chirps = append(chirps, chirp)
+	}
+
+	rec := doJSON(t, mux, "PATCH", "/api/chirps/"+chirps[1].ID.String(), author.Token, map[string]string{"body": "taken"})
+	if rec.Code != 409 {
+		t.Fatalf("Edit to another chirp's body: expected 409, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "DELETE", "/api/chirps/not-a-chirp", author.Token, nil)
+	if rec.Code != 404 {
+		t.Fatalf("Delete with a malformed ID: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestGetChirpMalformedID(t *testing.T) {
+	cfg := &ApiConfig{}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/chirps/{chirpID}", http.HandlerFunc(cfg.GetChirpHandler))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps/not-a-chirp", nil))
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404, got %d", rec.Code)
+	}
+}
@@ -1,13 +1,50 @@
 package config
 
 import (
+	"chirpy/internal/auth"
+	"chirpy/internal/billing"
 	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"chirpy/internal/mail"
+	"database/sql"
+	"sync"
 	"sync/atomic"
 )
 
 type ApiConfig struct {
-	FileserverHits atomic.Int32
-	Db             database.Queries
-	TokenSecret    string
-	PolkaKey       string
+	FileserverHits   atomic.Int32
+	Db               database.Queries
+	TokenSecret      string
+	IntrospectionKey string
+	Keys             *auth.Keyring
+	Revocations      *auth.RevocationList
+	SigningAlgorithm string
+	Passwords        auth.PasswordHasher
+	Mailer           mail.Mailer
+	BaseURL          string
+	// RequireVerifiedEmail stops users who have not verified their email
+	// from posting chirps.
+	RequireVerifiedEmail bool
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For; only set
+	// it when running behind a proxy that overwrites that header.
+	TrustProxyHeaders bool
+	// OIDC is the SSO provider users can log in with, or nil if there is none.
+	OIDC *auth.OIDCProvider
+	// PaymentProviders are the payment processors webhooks are accepted
+	// from, by the name used in their webhook URL.
+	PaymentProviders map[string]billing.Provider
+	// Entitlements are the limits and features of each plan.
+	Entitlements entitlements.Catalog
+	// Conn is the connection pool Db runs on, for queries that must share
+	// a transaction.
+	Conn *sql.DB
+	// SigningKeySecret encrypts the private signing keys stored in the
+	// database.
+	SigningKeySecret string
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+
+	pendingEmailsOnce sync.Once
+	pendingEmails     chan struct{}
 }
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// In cookie mode the access and refresh tokens live in HttpOnly cookies that
+// page scripts can't read. Scripts instead echo the readable CSRF cookie back
+// in a header on every state-changing request.
+const (
+	accessTokenCookie  = "chirpy_access_token"
+	refreshTokenCookie = "chirpy_refresh_token"
+	csrfCookie         = "chirpy_csrf_token"
+	csrfHeader         = "X-CSRF-Token"
+
+	refreshTokenLifetime = 60 * 24 * time.Hour
+)
+
+var refreshTokenCookiePaths = []string{"/api/refresh", "/api/revoke"}
+
+var errInvalidCSRFToken = errors.New("missing or invalid CSRF token")
+
+// csrfToken is derived from the session rather than random so that a cookie
+// planted by another site can't be paired with the victim's session.
+func (cfg *ApiConfig) csrfToken(sessionID string) string {
+	return auth.HashToken("csrf:"+sessionID, cfg.TokenSecret)
+}
+
+// secureCookies reports whether cookies should be sent over HTTPS only,
+// which is the case unless the server runs on plain HTTP, as it does locally.
+func (cfg *ApiConfig) secureCookies() bool {
+	return strings.HasPrefix(cfg.BaseURL, "https://")
+}
+
+func (cfg *ApiConfig) setSessionCookies(w http.ResponseWriter, accessToken string, accessExpiresIn time.Duration, refreshToken, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(accessExpiresIn.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Only the refresh and revoke endpoints ever need the refresh token, so
+	// it is set once for each of their paths
+	for _, path := range refreshTokenCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshTokenCookie,
+			Value:    refreshToken,
+			Path:     path,
+			MaxAge:   int(refreshTokenLifetime.Seconds()),
+			HttpOnly: true,
+			Secure:   cfg.secureCookies(),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    cfg.csrfToken(sessionID),
+		Path:     "/",
+		MaxAge:   int(refreshTokenLifetime.Seconds()),
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (cfg *ApiConfig) clearSessionCookies(w http.ResponseWriter) {
+	cookies := []http.Cookie{
+		{Name: accessTokenCookie, Path: "/"},
+		{Name: csrfCookie, Path: "/"},
+	}
+	for _, path := range refreshTokenCookiePaths {
+		cookies = append(cookies, http.Cookie{Name: refreshTokenCookie, Path: path})
+	}
+
+	for _, cookie := range cookies {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookie.Name,
+			Path:     cookie.Path,
+			MaxAge:   -1,
+			Secure:   cfg.secureCookies(),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// requestToken reads a bearer token from the Authorization header, falling
+// back to the named cookie. fromCookie tells the caller to check CSRF.
+func requestToken(r *http.Request, cookieName string) (token string, fromCookie bool, err error) {
+	if r.Header.Get("Authorization") != "" {
+		token, err = auth.GetToken(r.Header, "Bearer ")
+		return token, false, err
+	}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false, errors.New("no authorization header or session cookie")
+	}
+
+	return cookie.Value, true, nil
+}
+
+// checkCSRF applies the double-submit check to state-changing requests made
+// with session cookies. The header must match both the cookie and the
+// session the cookie-borne token belongs to.
+func (cfg *ApiConfig) checkCSRF(r *http.Request, sessionID string) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+
+	header := r.Header.Get(csrfHeader)
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || header == "" || sessionID == "" {
+		return errInvalidCSRFToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return errInvalidCSRFToken
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cfg.csrfToken(sessionID))) != 1 {
+		return errInvalidCSRFToken
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	cfg := &ApiConfig{TokenSecret: testTokenSecret}
+	sessionID := uuid.NewString()
+	token := cfg.csrfToken(sessionID)
+
+	cases := []struct {
+		name      string
+		method    string
+		header    string
+		cookie    string
+		sessionID string
+		wantErr   bool
+	}{
+		{"GET is exempt", "GET", "", "", sessionID, false},
+		{"HEAD is exempt", "HEAD", "", "", sessionID, false},
+		{"matching header and cookie", "POST", token, token, sessionID, false},
+		{"missing header", "POST", "", token, sessionID, true},
+		{"missing cookie", "DELETE", token, "", sessionID, true},
+		{"header does not match cookie", "POST", token, cfg.csrfToken(uuid.NewString()), sessionID, true},
+		{"token from another session", "POST", cfg.csrfToken(uuid.NewString()), cfg.csrfToken(uuid.NewString()), sessionID, true},
+		{"planted matching pair from another session", "PUT", "planted", "planted", sessionID, true},
+		{"no session", "POST", token, token, "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, "/api/refresh", nil)
+			if c.header != "" {
+				req.Header.Set(csrfHeader, c.header)
+			}
+			if c.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookie, Value: c.cookie})
+			}
+
+			err := cfg.checkCSRF(req, c.sessionID)
+			if c.wantErr && !errors.Is(err, errInvalidCSRFToken) {
+				t.Fatalf("Expected errInvalidCSRFToken, got %v", err)
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	cases := []struct {
+		name           string
+		authorization  string
+		cookie         string
+		wantToken      string
+		wantFromCookie bool
+		wantErr        bool
+	}{
+		{"header", "Bearer header-token", "", "header-token", false, false},
+		{"header wins over cookie", "Bearer header-token", "cookie-token", "header-token", false, false},
+		{"falls back to cookie", "", "cookie-token", "cookie-token", true, false},
+		{"malformed header does not fall back", "Basic abc", "cookie-token", "", false, true},
+		{"empty cookie", "", "", "", false, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/refresh", nil)
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+			req.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: c.cookie})
+
+			token, fromCookie, err := requestToken(req, refreshTokenCookie)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got token %q", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if token != c.wantToken || fromCookie != c.wantFromCookie {
+				t.Fatalf("Expected (%q, %t), got (%q, %t)", c.wantToken, c.wantFromCookie, token, fromCookie)
+			}
+		})
+	}
+}
+
+func TestRefreshCookiePaths(t *testing.T) {
+	cfg := &ApiConfig{TokenSecret: testTokenSecret}
+
+	rec := httptest.NewRecorder()
+	cfg.setSessionCookies(rec, "access", time.Hour, "refresh", uuid.NewString())
+
+	paths := []string{}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == refreshTokenCookie {
+			paths = append(paths, cookie.Path)
+		}
+	}
+
+	slices.Sort(paths)
+	if !slices.Equal(paths, []string{"/api/refresh", "/api/revoke"}) {
+		t.Fatalf("Refresh token cookie set for paths %v", paths)
+	}
+}
+
+func TestSessionCookiesSecureOverHTTPS(t *testing.T) {
+	for baseURL, wantSecure := range map[string]bool{
+		"https://chirpy.example.com": true,
+		"http://localhost:8080":      false,
+	} {
+		cfg := &ApiConfig{TokenSecret: testTokenSecret, BaseURL: baseURL}
+
+		rec := httptest.NewRecorder()
+		cfg.setSessionCookies(rec, "access", time.Hour, "refresh", uuid.NewString())
+		cfg.clearSessionCookies(rec)
+
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Secure != wantSecure {
+				t.Fatalf("%s: expected cookie %s on %s to have Secure %t", baseURL, cookie.Name, cookie.Path, wantSecure)
+			}
+		}
+	}
+}
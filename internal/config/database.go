@@ -0,0 +1,56 @@
+package config
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// WithSessionParam sets a Postgres run-time parameter, such as TimeZone, on
+// every connection made with a connection string in either URL or key=value
+// form.
+//
+// Chirpy connects with the time zone set to UTC. Times are written both by
+// Go, always in UTC, and by NOW() in queries, so columns without a time zone
+// only agree with each other when the session is in UTC as well.
+func WithSessionParam(dbURL, key, value string) string {
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		parsed, err := url.Parse(dbURL)
+		if err == nil {
+			query := parsed.Query()
+			query.Set(key, value)
+			parsed.RawQuery = query.Encode()
+			return parsed.String()
+		}
+	}
+
+	return dbURL + " " + key + "=" + value
+}
+
+// inTx runs fn with queries that share one transaction, which is committed if
+// fn succeeds and rolled back otherwise.
+func (cfg *ApiConfig) inTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	tx, err := cfg.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(cfg.Db.WithTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isUniqueViolation reports whether err is Postgres refusing a row that would
+// duplicate one already stored under a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"bytes"
+	"chirpy/internal/auth"
+	"chirpy/internal/billing"
+	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"chirpy/internal/mail"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testTokenSecret      = "test-token-secret"
+	testIntrospectionKey = "test-introspection-key"
+)
+
+// newTestConfig returns an ApiConfig backed by a fresh schema, with every
+// migration applied, in the Postgres database at CHIRPY_TEST_DB_URL. Tests
+// that need a database are skipped when it is not set. The schema is dropped
+// when the test ends. The connection is returned for tests that need to set
+// up state no endpoint can, such as expired tokens.
+func newTestConfig(t *testing.T) (*ApiConfig, *mail.MemoryMailer, *sql.DB) {
+	t.Helper()
+
+	dbURL := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_DB_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "chirpy_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		t.Fatalf("Error creating schema: %s", err)
+	}
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Logf("Error dropping schema %s: %s", schema, err)
+		}
+	})
+
+	db, err := sql.Open("postgres", WithSessionParam(WithSessionParam(dbURL, "search_path", schema+",public"), "timezone", "UTC"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrateTestDB(t, db)
+
+	key, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	revocations := auth.NewRevocationList()
+	keys := auth.NewKeyring(key)
+	keys.SetRevocationList(revocations)
+
+	mailer := &mail.MemoryMailer{}
+
+	cfg := &ApiConfig{
+		Db:               *database.New(db),
+		Conn:             db,
+		TokenSecret:      testTokenSecret,
+		IntrospectionKey: testIntrospectionKey,
+		Keys:             keys,
+		Revocations:      revocations,
+		SigningAlgorithm: auth.AlgEdDSA,
+		Passwords:        auth.NewPasswordHasher(auth.BcryptHasher{Cost: bcrypt.MinCost}),
+		Mailer:           mailer,
+		BaseURL:          "http://localhost:8080",
+		PaymentProviders: map[string]billing.Provider{},
+		Entitlements:     entitlements.DefaultCatalog(),
+	}
+
+	return cfg, mailer, db
+}
+
+// migrateTestDB applies the Up half of every migration the way goose would.
+func migrateTestDB(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	files, err := filepath.Glob("../../sql/schema/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No migrations found: %v", err)
+	}
+
+	for _, file := range files {
+		dat, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		up, _, _ := strings.Cut(string(dat), "-- +goose Down")
+
+		lines := []string{}
+		for _, line := range strings.Split(up, "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "-- +goose") {
+				lines = append(lines, line)
+			}
+		}
+
+		_, err = db.Exec(strings.Join(lines, "\n"))
+		if err != nil {
+			t.Fatalf("Error applying %s: %s", filepath.Base(file), err)
+		}
+	}
+}
+
+// doJSON sends body, if any, as JSON to handler with token as the bearer
+// token, if any.
+func doJSON(t *testing.T, handler http.Handler, method, target, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		reader = bytes.NewReader(dat)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// doForm posts form to handler, with the Authorization header set to
+// authorization if it is not empty.
+func doForm(t *testing.T, handler http.Handler, target, authorization string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, out any) {
+	t.Helper()
+
+	err := json.Unmarshal(rec.Body.Bytes(), out)
+	if err != nil {
+		t.Fatalf("Error decoding response %q: %s", rec.Body.String(), err)
+	}
+}
+
+type testSession struct {
+	UserID       uuid.UUID `json:"id"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+}
+
+// registerAndLogin creates a user through the API and logs them in.
+func registerAndLogin(t *testing.T, cfg *ApiConfig, email, password string) testSession {
+	t.Helper()
+
+	credentials := map[string]string{"email": email, "password": password}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.UsersHandler), "POST", "/api/users", "", credentials)
+	if rec.Code != 201 {
+		t.Fatalf("Registering %s: expected 201, got %d", email, rec.Code)
+	}
+
+	return loginTestUser(t, cfg, email, password)
+}
+
+func loginTestUser(t *testing.T, cfg *ApiConfig, email, password string) testSession {
+	t.Helper()
+
+	credentials := map[string]string{"email": email, "password": password}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.LoginHandler), "POST", "/api/login", "", credentials)
+	if rec.Code != 200 {
+		t.Fatalf("Logging in %s: expected 200, got %d", email, rec.Code)
+	}
+
+	session := testSession{}
+	decodeJSON(t, rec, &session)
+	return session
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"chirpy/internal/entitlements"
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// entitlementsFor looks up what the user's current plan lets them do.
+func (cfg *ApiConfig) entitlementsFor(ctx context.Context, userID uuid.UUID) entitlements.Entitlements {
+	return cfg.Entitlements.For(entitlements.PlanFor(cfg.isChirpyRed(ctx, userID)))
+}
+
+// entitlementsResponse is how a user's entitlements are shown to clients.
+type entitlementsResponse struct {
+	Plan              entitlements.Plan `json:"plan"`
+	MaxChirpLength    int               `json:"max_chirp_length"`
+	EditWindowSeconds int               `json:"edit_window_seconds"`
+	ChirpsPerHour     int               `json:"chirps_per_hour"`
+}
+
+func newEntitlementsResponse(ents entitlements.Entitlements) entitlementsResponse {
+	return entitlementsResponse{
+		Plan:              ents.Plan,
+		MaxChirpLength:    ents.MaxChirpLength,
+		EditWindowSeconds: int(ents.EditWindow.Seconds()),
+		ChirpsPerHour:     ents.ChirpsPerHour,
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/entitlements"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// introspection is an RFC 7662 token introspection response. Inactive tokens
+// are reported with every other field left out.
+type introspection struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// IntrospectHandler lets internal services holding the introspection key ask
+// whether any Chirpy token is valid. Scope is left out for a user's own
+// session tokens, which are not limited to any scopes.
+func (cfg *ApiConfig) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := auth.GetToken(r.Header, "ApiKey ")
+	if err != nil {
+		log.Printf("Error parsing API key: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	if cfg.IntrospectionKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(cfg.IntrospectionKey)) != 1 {
+		log.Printf("Invalid introspection key")
+		w.WriteHeader(401)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		log.Printf("Error parsing form: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	respStruct := cfg.introspect(r, r.PostForm.Get("token"))
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+// introspect works out what kind of token it was given from its shape, so
+// token_type_hint is not needed.
+func (cfg *ApiConfig) introspect(r *http.Request, token string) introspection {
+	if token == "" {
+		return introspection{}
+	}
+
+	if auth.IsPersonalAccessToken(token) {
+		pat, err := cfg.Db.GetPersonalAccessToken(r.Context(), auth.HashToken(token, cfg.TokenSecret))
+		if err != nil {
+			return introspection{}
+		}
+
+		resp := introspection{
+			Active:    true,
+			TokenType: "personal_access_token",
+			Subject:   pat.UserID.String(),
+			Scope:     pat.Scopes,
+			IssuedAt:  pat.CreatedAt.Unix(),
+		}
+		if pat.ExpiresAt.Valid {
+			resp.ExpiresAt = pat.ExpiresAt.Time.Unix()
+		}
+		return resp
+	}
+
+	if strings.Count(token, ".") == 2 {
+		claims, err := auth.ParseJWT(token, cfg.Keys, auth.AccessTokenAudience)
+		if err != nil {
+			return introspection{}
+		}
+
+		return introspection{
+			Active:    true,
+			TokenType: "access_token",
+			Subject:   claims.Subject,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			ExpiresAt: claims.ExpiresAt,
+			IssuedAt:  claims.IssuedAt,
+		}
+	}
+
+	tokenHash := auth.HashToken(token, cfg.TokenSecret)
+
+	refreshToken, err := cfg.Db.GetRefreshToken(r.Context(), tokenHash)
+	if err == nil {
+		if refreshToken.RevokedAt.Valid || time.Now().After(refreshToken.ExpiresAt) {
+			return introspection{}
+		}
+
+		return introspection{
+			Active:    true,
+			TokenType: "refresh_token",
+			Subject:   refreshToken.UserID.String(),
+			ExpiresAt: refreshToken.ExpiresAt.Unix(),
+			IssuedAt:  refreshToken.CreatedAt.Unix(),
+		}
+	}
+
+	oauthToken, err := cfg.Db.GetOAuthRefreshToken(r.Context(), tokenHash)
+	if err == nil {
+		if oauthToken.RevokedAt.Valid || time.Now().After(oauthToken.ExpiresAt) {
+			return introspection{}
+		}
+
+		return introspection{
+			Active:    true,
+			TokenType: "refresh_token",
+			Subject:   oauthToken.UserID.String(),
+			Scope:     oauthToken.Scopes,
+			ClientID:  oauthToken.ClientID,
+			ExpiresAt: oauthToken.ExpiresAt.Unix(),
+			IssuedAt:  oauthToken.CreatedAt.Unix(),
+		}
+	}
+
+	return introspection{}
+}
+
+func (cfg *ApiConfig) MeHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, auth.ScopeProfileRead)
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	type returnVals struct {
+		ID            uuid.UUID            `json:"id"`
+		Email         string               `json:"email"`
+		Created_at    time.Time            `json:"created_at"`
+		Updated_at    time.Time            `json:"updated_at"`
+		IsChirpyRed   bool                 `json:"is_chirpy_red"`
+		EmailVerified bool                 `json:"email_verified"`
+		Role          string               `json:"role"`
+		MFAEnabled    bool                 `json:"mfa_enabled"`
+		Entitlements  entitlementsResponse `json:"entitlements"`
+	}
+
+	ents := cfg.entitlementsFor(r.Context(), user.ID)
+
+	respStruct := returnVals{
+		ID:            user.ID,
+		Email:         user.Email,
+		Created_at:    user.CreatedAt,
+		Updated_at:    user.UpdatedAt,
+		IsChirpyRed:   ents.Plan == entitlements.PlanChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
+		MFAEnabled:    user.TotpEnabledAt.Valid,
+		Entitlements:  newEntitlementsResponse(ents),
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func introspectToken(t *testing.T, handler http.Handler, token string) introspection {
+	t.Helper()
+
+	rec := doForm(t, handler, "/api/oauth/introspect", "ApiKey "+testIntrospectionKey, url.Values{"token": {token}})
+	if rec.Code != 200 {
+		t.Fatalf("Introspect: expected 200, got %d", rec.Code)
+	}
+
+	resp := introspection{}
+	decodeJSON(t, rec, &resp)
+	return resp
+}
+
+func TestIntrospectRequiresKey(t *testing.T) {
+	cases := []struct {
+		name          string
+		configured    string
+		authorization string
+	}{
+		{"missing key", testIntrospectionKey, ""},
+		{"wrong key", testIntrospectionKey, "ApiKey wrong-key"},
+		{"wrong scheme", testIntrospectionKey, "Bearer " + testIntrospectionKey},
+		{"introspection disabled", "", "ApiKey " + testIntrospectionKey},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := &ApiConfig{IntrospectionKey: c.configured}
+
+			rec := doForm(t, http.HandlerFunc(cfg.IntrospectHandler), "/api/oauth/introspect", c.authorization, url.Values{"token": {"anything"}})
+			if rec.Code != 401 {
+				t.Fatalf("Expected 401, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestIntrospectAccessToken(t *testing.T) {
+	key, err := auth.GenerateSigningKey(auth.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	revocations := auth.NewRevocationList()
+	keys := auth.NewKeyring(key)
+	keys.SetRevocationList(revocations)
+
+	cfg := &ApiConfig{IntrospectionKey: testIntrospectionKey, Keys: keys, Revocations: revocations}
+	handler := http.HandlerFunc(cfg.IntrospectHandler)
+
+	userID := uuid.New()
+
+	token, err := auth.MakeJWT(userID, keys, time.Hour, auth.WithClient("client", []string{auth.ScopeChirpsRead}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	resp := introspectToken(t, handler, token)
+	if !resp.Active || resp.TokenType != "access_token" || resp.Subject != userID.String() || resp.ClientID != "client" || resp.Scope != auth.ScopeChirpsRead {
+		t.Fatalf("Access token introspected as %+v", resp)
+	}
+
+	expired, err := auth.MakeJWT(userID, keys, -time.Minute)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if resp := introspectToken(t, handler, expired); resp != (introspection{}) {
+		t.Fatalf("Expired access token introspected as %+v", resp)
+	}
+
+	revocations.Add(auth.RevokedUserKey(userID), time.Now().Add(time.Second))
+
+	if resp := introspectToken(t, handler, token); resp != (introspection{}) {
+		t.Fatalf("Revoked access token introspected as %+v", resp)
+	}
+
+	if resp := introspectToken(t, handler, ""); resp != (introspection{}) {
+		t.Fatalf("Empty token introspected as %+v", resp)
+	}
+}
+
+// expireToken backdates the expiry of a stored token, which no endpoint can.
+func expireToken(t *testing.T, db *sql.DB, table, token string) {
+	t.Helper()
+
+	result, err := db.Exec("UPDATE "+table+" SET expires_at = NOW() - INTERVAL '1 minute' WHERE token_hash = $1", auth.HashToken(token, testTokenSecret))
+	if err != nil {
+		t.Fatalf("Error expiring token: %s", err)
+	}
+	if rows, _ := result.RowsAffected(); rows != 1 {
+		t.Fatalf("Expected to expire one token in %s, expired %d", table, rows)
+	}
+}
+
+func TestIntrospectRefreshTokens(t *testing.T) {
+	cfg, _, db := newTestConfig(t)
+	session := registerAndLogin(t, cfg, "introspect@example.com", "introspect-password")
+
+	mux := newOAuthTestMux(cfg)
+	mux.Handle("POST /api/refresh", http.HandlerFunc(cfg.RefreshHandler))
+	mux.Handle("POST /api/revoke", http.HandlerFunc(cfg.RevokeHandler))
+
+	resp := introspectToken(t, mux, session.RefreshToken)
+	if !resp.Active || resp.TokenType != "refresh_token" || resp.Subject != session.UserID.String() || resp.ClientID != "" {
+		t.Fatalf("Refresh token introspected as %+v", resp)
+	}
+
+	rec := doJSON(t, mux, "POST", "/api/refresh", session.RefreshToken, nil)
+	if rec.Code != 200 {
+		t.Fatalf("Refresh: expected 200, got %d", rec.Code)
+	}
+
+	refreshed := testSession{}
+	decodeJSON(t, rec, &refreshed)
+
+	if resp := introspectToken(t, mux, session.RefreshToken); resp.Active {
+		t.Fatalf("Rotated refresh token is still active")
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/revoke", refreshed.RefreshToken, nil)
+	if rec.Code != 204 {
+		t.Fatalf("Revoke: expected 204, got %d", rec.Code)
+	}
+
+	if resp := introspectToken(t, mux, refreshed.RefreshToken); resp.Active {
+		t.Fatalf("Revoked refresh token is still active")
+	}
+
+	rec = doJSON(t, http.HandlerFunc(cfg.LoginHandler), "POST", "/api/login", "", map[string]string{"email": "introspect@example.com", "password": "introspect-password"})
+	second := testSession{}
+	decodeJSON(t, rec, &second)
+	expireToken(t, db, "refresh_tokens", second.RefreshToken)
+
+	if resp := introspectToken(t, mux, second.RefreshToken); resp.Active {
+		t.Fatalf("Expired refresh token is still active")
+	}
+
+	clientID, issued := authorizeTestClient(t, mux, session, "introspect@example.com", "introspect-password")
+
+	resp = introspectToken(t, mux, issued.RefreshToken)
+	if !resp.Active || resp.TokenType != "refresh_token" || resp.ClientID != clientID || resp.Scope != auth.ScopeChirpsRead {
+		t.Fatalf("OAuth refresh token introspected as %+v", resp)
+	}
+
+	oauthRefreshed := refreshOAuthToken(t, mux, clientID, issued.RefreshToken)
+	if oauthRefreshed == nil {
+		t.Fatalf("OAuth refresh failed")
+	}
+
+	if resp := introspectToken(t, mux, issued.RefreshToken); resp.Active {
+		t.Fatalf("Rotated OAuth refresh token is still active")
+	}
+
+	expireToken(t, db, "oauth_refresh_tokens", oauthRefreshed.RefreshToken)
+
+	if resp := introspectToken(t, mux, oauthRefreshed.RefreshToken); resp.Active {
+		t.Fatalf("Expired OAuth refresh token is still active")
+	}
+
+	otherClientID, revoked := authorizeTestClient(t, mux, session, "introspect@example.com", "introspect-password")
+
+	rec = doForm(t, mux, "/oauth/revoke", "", url.Values{"client_id": {otherClientID}, "token": {revoked.RefreshToken}})
+	if rec.Code != 200 {
+		t.Fatalf("OAuth revoke: expected 200, got %d", rec.Code)
+	}
+
+	if resp := introspectToken(t, mux, revoked.RefreshToken); resp.Active {
+		t.Fatalf("Revoked OAuth refresh token is still active")
+	}
+}
+
+func TestIntrospectPersonalAccessTokens(t *testing.T) {
+	cfg, _, db := newTestConfig(t)
+	session := registerAndLogin(t, cfg, "pat@example.com", "pat-password")
+
+	mux := newOAuthTestMux(cfg)
+	mux.Handle("POST /api/tokens", http.HandlerFunc(cfg.CreatePersonalAccessTokenHandler))
+	mux.Handle("DELETE /api/tokens/{tokenID}", http.HandlerFunc(cfg.RevokePersonalAccessTokenHandler))
+
+	createPAT := func() personalAccessTokenResponse {
+		rec := doJSON(t, mux, "POST", "/api/tokens", session.Token, map[string]any{
+			"name":   "ci",
+			"scopes": []string{auth.ScopeChirpsRead},
+		})
+		if rec.Code != 201 {
+			t.Fatalf("Creating token: expected 201, got %d", rec.Code)
+		}
+
+		pat := personalAccessTokenResponse{}
+		decodeJSON(t, rec, &pat)
+		return pat
+	}
+
+	pat := createPAT()
+
+	resp := introspectToken(t, mux, pat.Token)
+	if !resp.Active || resp.TokenType != "personal_access_token" || resp.Subject != session.UserID.String() || resp.Scope != auth.ScopeChirpsRead {
+		t.Fatalf("Personal access token introspected as %+v", resp)
+	}
+
+	rec := doJSON(t, mux, "DELETE", "/api/tokens/"+pat.ID.String(), session.Token, nil)
+	if rec.Code != 204 {
+		t.Fatalf("Revoking token: expected 204, got %d", rec.Code)
+	}
+
+	if resp := introspectToken(t, mux, pat.Token); resp.Active {
+		t.Fatalf("Revoked personal access token is still active")
+	}
+
+	expiring := createPAT()
+	expireToken(t, db, "personal_access_tokens", expiring.Token)
+
+	if resp := introspectToken(t, mux, expiring.Token); resp.Active {
+		t.Fatalf("Expired personal access token is still active")
+	}
+}
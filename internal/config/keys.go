@@ -0,0 +1,167 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// Retired keys stay in the JWKS until every token they signed has expired.
+	maxAccessTokenLifetime = time.Hour
+
+	jwksMaxAge        = 5 * time.Minute
+	keyReloadInterval = time.Minute
+
+	// SigningKeyActivationDelay keeps a new key out of signing until every
+	// instance has reloaded it and every cached JWKS has expired.
+	SigningKeyActivationDelay = jwksMaxAge + keyReloadInterval
+)
+
+func (cfg *ApiConfig) LoadSigningKeys(ctx context.Context) error {
+	rows, err := cfg.Db.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := []auth.SigningKey{}
+	for _, row := range rows {
+		privatePEM, sealed, err := auth.OpenPrivateKey(row.ID, row.PrivateKey, cfg.SigningKeySecret)
+		if err != nil {
+			log.Printf("Skipping unreadable signing key %s: %s", row.ID, err)
+			continue
+		}
+
+		key, err := auth.ParseSigningKey(row.ID, row.Algorithm, privatePEM, row.CreatedAt)
+		if err != nil {
+			log.Printf("Skipping unreadable signing key %s: %s", row.ID, err)
+			continue
+		}
+		keys = append(keys, key)
+
+		// Keys stored before they were encrypted are sealed the first time
+		// they are read
+		if !sealed {
+			cfg.sealStoredSigningKey(ctx, row.ID, privatePEM)
+		}
+	}
+
+	cfg.Keys.Replace(keys)
+	return nil
+}
+
+func (cfg *ApiConfig) RotateSigningKey(ctx context.Context) error {
+	key, err := auth.GenerateSigningKey(cfg.SigningAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	privatePEM, err := key.PrivateKeyPEM()
+	if err != nil {
+		return err
+	}
+
+	sealed, err := auth.SealPrivateKey(key.ID, privatePEM, cfg.SigningKeySecret)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.Db.CreateSigningKey(ctx, database.CreateSigningKeyParams{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: sealed,
+		CreatedAt:  key.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The previous key keeps signing until the new one activates, so its
+	// tokens can be issued for that long after retirement starts.
+	err = cfg.Db.RetireSigningKeys(ctx, database.RetireSigningKeysParams{
+		RetiredAt: sql.NullTime{Time: time.Now().UTC().Add(SigningKeyActivationDelay + maxAccessTokenLifetime), Valid: true},
+		ID:        key.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Db.DeleteRetiredSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Rotated signing key, new kid: %s", key.ID)
+	return cfg.LoadSigningKeys(ctx)
+}
+
+func (cfg *ApiConfig) sealStoredSigningKey(ctx context.Context, id, privatePEM string) {
+	sealed, err := auth.SealPrivateKey(id, privatePEM, cfg.SigningKeySecret)
+	if err != nil {
+		log.Printf("Error sealing signing key %s: %s", id, err)
+		return
+	}
+
+	err = cfg.Db.SealSigningKey(ctx, database.SealSigningKeyParams{
+		ID:         id,
+		PrivateKey: sealed,
+	})
+	if err != nil {
+		log.Printf("Error storing sealed signing key %s: %s", id, err)
+		return
+	}
+
+	log.Printf("Sealed signing key %s", id)
+}
+
+// RotateSigningKeysEvery reloads the keyring on every tick so that all
+// instances pick up keys rotated elsewhere, and rotates once the newest key
+// is older than interval.
+func (cfg *ApiConfig) RotateSigningKeysEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := cfg.LoadSigningKeys(ctx)
+		if err != nil {
+			log.Printf("Error loading signing keys: %s", err)
+			continue
+		}
+
+		newest, err := cfg.Keys.Newest()
+		if err == nil && time.Since(newest.CreatedAt) < interval {
+			continue
+		}
+
+		err = cfg.RotateSigningKey(ctx)
+		if err != nil {
+			log.Printf("Error rotating signing key: %s", err)
+		}
+	}
+}
+
+func (cfg *ApiConfig) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	dat, err := json.Marshal(cfg.Keys.JWKS())
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	w.WriteHeader(200)
+	w.Write(dat)
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var accountLockout = auth.LockoutPolicy{
+	FreeAttempts: 5,
+	BaseLockout:  30 * time.Second,
+	MaxLockout:   time.Hour,
+	Window:       24 * time.Hour,
+}
+
+// An IP may be shared by many users, so it gets more room before locking,
+// and its count starts again an hour after its last failure. A successful
+// login doesn't clear it, since that says nothing about the other accounts
+// tried from the same address.
+var ipLockout = auth.LockoutPolicy{
+	FreeAttempts: 20,
+	BaseLockout:  time.Minute,
+	MaxLockout:   time.Hour,
+	Window:       time.Hour,
+}
+
+func accountLockoutKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (cfg *ApiConfig) clientIP(r *http.Request) string {
+	if cfg.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLockedOut returns how much longer the longest lock on keys lasts.
+func (cfg *ApiConfig) loginLockedOut(ctx context.Context, keys ...string) (time.Duration, error) {
+	var remaining time.Duration
+
+	for _, key := range keys {
+		failure, err := cfg.Db.GetLoginFailure(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if failure.LockedUntil.Valid {
+			remaining = max(remaining, time.Until(failure.LockedUntil.Time))
+		}
+	}
+
+	return remaining, nil
+}
+
+func (cfg *ApiConfig) recordLoginFailure(ctx context.Context, email, ip string) {
+	cfg.recordAttempts(ctx, map[string]auth.LockoutPolicy{
+		accountLockoutKey(email): accountLockout,
+		ipLockoutKey(ip):         ipLockout,
+	})
+}
+
+// recordAttempts counts an attempt against each key, locking any key its
+// policy says has had too many.
+func (cfg *ApiConfig) recordAttempts(ctx context.Context, policies map[string]auth.LockoutPolicy) {
+	for key, policy := range policies {
+		recordParams := database.RecordLoginFailureParams{
+			Key:         key,
+			WindowStart: time.Now().UTC().Add(-policy.Window),
+		}
+
+		failure, err := cfg.Db.RecordLoginFailure(ctx, recordParams)
+		if err != nil {
+			log.Printf("Error recording attempt: %s", err)
+			continue
+		}
+
+		lockout := policy.LockoutDuration(int(failure.Failures))
+		if lockout == 0 {
+			continue
+		}
+
+		lockParams := database.LockLoginParams{
+			Key:         key,
+			LockedUntil: sql.NullTime{Time: time.Now().UTC().Add(lockout), Valid: true},
+		}
+
+		err = cfg.Db.LockLogin(ctx, lockParams)
+		if err != nil {
+			log.Printf("Error locking %s: %s", key, err)
+			continue
+		}
+
+		log.Printf("Locked %s for %s after %d attempts", key, lockout, failure.Failures)
+	}
+}
+
+// clearLoginFailures resets the account counter once a login has fully
+// succeeded, including any second factor.
+func (cfg *ApiConfig) clearLoginFailures(ctx context.Context, email string) {
+	err := cfg.Db.ClearLoginFailures(ctx, accountLockoutKey(email))
+	if err != nil {
+		log.Printf("Error clearing login failures: %s", err)
+	}
+}
+
+func respondLockedOut(w http.ResponseWriter, remaining time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(remaining.Seconds()))))
+	w.WriteHeader(429)
+}
+
+// dummyPasswordHash is verified against when the email is unknown so that
+// response time doesn't reveal which accounts exist.
+func (cfg *ApiConfig) dummyPasswordHash() string {
+	cfg.dummyHashOnce.Do(func() {
+		hashed, err := cfg.Passwords.Hash("chirpy-dummy-password")
+		if err != nil {
+			log.Printf("Error creating dummy password hash: %s", err)
+			return
+		}
+		cfg.dummyHash = hashed
+	})
+
+	return cfg.dummyHash
+}
+
+func (cfg *ApiConfig) LockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	locked, err := cfg.Db.GetLockedLogins(r.Context())
+	if err != nil {
+		log.Printf("Error retrieving lockouts: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type lockout struct {
+		Key          string    `json:"key"`
+		Failures     int32     `json:"failures"`
+		LastFailedAt time.Time `json:"last_failed_at"`
+		LockedUntil  time.Time `json:"locked_until"`
+	}
+
+	respStruct := []lockout{}
+	for _, row := range locked {
+		respStruct = append(respStruct, lockout{
+			Key:          row.Key,
+			Failures:     row.Failures,
+			LastFailedAt: row.LastFailedAt,
+			LockedUntil:  row.LockedUntil.Time,
+		})
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) ClearLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.Db.ClearLoginFailures(r.Context(), r.PathValue("key"))
+	if err != nil {
+		log.Printf("Error clearing lockout: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditClearLockout, Outcome: auditSuccess, ActorID: adminActor(r), Details: r.PathValue("key")})
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestIPLockoutCountsWithinWindow(t *testing.T) {
+	cfg, _, db := newTestConfig(t)
+	registerAndLogin(t, cfg, "lockout@example.com", "lockout-password")
+
+	failLogin := func() {
+		t.Helper()
+		credentials := map[string]string{"email": "lockout@example.com", "password": "wrong-password"}
+		rec := doJSON(t, http.HandlerFunc(cfg.LoginHandler), "POST", "/api/login", "", credentials)
+		if rec.Code != 401 {
+			t.Fatalf("Login with the wrong password: expected 401, got %d", rec.Code)
+		}
+	}
+
+	failures := func(key string) int32 {
+		t.Helper()
+		failure, err := cfg.Db.GetLoginFailure(context.Background(), key)
+		if err != nil {
+			return 0
+		}
+		return failure.Failures
+	}
+
+	// httptest requests all come from 192.0.2.1
+	ipKey := ipLockoutKey("192.0.2.1")
+	emailKey := accountLockoutKey("lockout@example.com")
+
+	for range 3 {
+		failLogin()
+	}
+	if failures(ipKey) != 3 || failures(emailKey) != 3 {
+		t.Fatalf("Expected 3 failures for the IP and the account, got %d and %d", failures(ipKey), failures(emailKey))
+	}
+
+	loginTestUser(t, cfg, "lockout@example.com", "lockout-password")
+	if failures(emailKey) != 0 {
+		t.Fatalf("Expected a login to clear the account's failures, got %d", failures(emailKey))
+	}
+	if failures(ipKey) != 3 {
+		t.Fatalf("Expected a login to leave the IP's failures, got %d", failures(ipKey))
+	}
+
+	_, err := db.Exec("UPDATE login_failures SET last_failed_at = NOW() - INTERVAL '2 hours' WHERE key = $1", ipKey)
+	if err != nil {
+		t.Fatalf("Error backdating failures: %s", err)
+	}
+
+	failLogin()
+	if failures(ipKey) != 1 {
+		t.Fatalf("Expected the IP's count to start again after its window, got %d", failures(ipKey))
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/mail"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const magicLinkLifetime = 15 * time.Minute
+
+func (cfg *ApiConfig) MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	remaining, err := cfg.limitEmailRequest(r.Context(), params.Email, cfg.clientIP(r))
+	if err != nil {
+		log.Printf("Error checking email rate limit: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if remaining > 0 {
+		log.Printf("Magic link requests rate limited")
+		respondLockedOut(w, remaining)
+		return
+	}
+
+	// Same as a forgotten password: don't reveal whether the account exists
+	cfg.sendInBackground(func(ctx context.Context) {
+		cfg.sendMagicLink(ctx, params.Email)
+	})
+
+	w.WriteHeader(202)
+}
+
+func (cfg *ApiConfig) sendMagicLink(ctx context.Context, email string) {
+	user, err := cfg.Db.GetUser(ctx, email)
+	if err != nil {
+		log.Printf("Magic link requested for unknown email: %s", err)
+		return
+	}
+
+	payload := auth.SignedToken{
+		ID:        uuid.NewString(),
+		Purpose:   auth.PurposeMagicLogin,
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(magicLinkLifetime).Unix(),
+	}
+
+	token, err := auth.MakeSignedToken(payload, cfg.TokenSecret)
+	if err != nil {
+		log.Printf("Error generating magic link token: %s", err)
+		return
+	}
+
+	link := cfg.BaseURL + "/app/magic-login?token=" + url.QueryEscape(token)
+
+	err = cfg.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy login link",
+		Body:    fmt.Sprintf("Open the link below within 15 minutes to log in to Chirpy. It can only be used once. If you didn't ask for it, you can ignore this email.\n\n%s\n", link),
+	})
+	if err != nil {
+		log.Printf("Error sending magic link email: %s", err)
+	}
+}
+
+// MagicLinkLoginHandler exchanges the token from a magic link for the usual
+// token pair. It is a POST, not the link itself, so mail scanners that follow
+// links can't use up the token.
+func (cfg *ApiConfig) MagicLinkLoginHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token       string `json:"token"`
+		DeviceLabel string `json:"device_label"`
+		UseCookies  bool   `json:"use_cookies"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	token, err := auth.ParseSignedToken(params.Token, cfg.TokenSecret, auth.PurposeMagicLogin)
+	if err != nil {
+		log.Printf("Invalid magic link token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := uuid.Parse(token.Subject)
+	if err != nil {
+		log.Printf("Invalid magic link token subject: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	fresh, err := cfg.consumeSignedToken(r.Context(), token)
+	if err != nil {
+		log.Printf("Error consuming magic link token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !fresh {
+		log.Printf("Magic link token already used")
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	if user.Email != token.Email {
+		log.Printf("Email changed since magic link was sent")
+		w.WriteHeader(401)
+		return
+	}
+
+	// Opening the link proves the user owns the address
+	if !user.EmailVerifiedAt.Valid {
+		verifyParams := database.VerifyEmailParams{
+			ID:    user.ID,
+			Email: user.Email,
+		}
+
+		_, err = cfg.Db.VerifyEmail(r.Context(), verifyParams)
+		if err != nil {
+			log.Printf("Error verifying email: %s", err)
+		}
+	}
+
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user, loginOptions{
+		ExpiresIn:   time.Hour,
+		DeviceLabel: params.DeviceLabel,
+		UseCookies:  params.UseCookies,
+	})
+}
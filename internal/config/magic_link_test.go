@@ -0,0 +1,115 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/mail"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// waitForMessage returns the first email with subject sent to to, waiting for
+// mail sent in the background.
+func waitForMessage(t *testing.T, mailer *mail.MemoryMailer, to, subject string) mail.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, msg := range mailer.Messages() {
+			if msg.To == to && msg.Subject == subject {
+				return msg
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("No %q email sent to %s", subject, to)
+	return mail.Message{}
+}
+
+func magicLinkToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+
+	_, rest, found := strings.Cut(msg.Body, "?token=")
+	if !found {
+		t.Fatalf("Magic link email has no token: %q", msg.Body)
+	}
+
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return token
+}
+
+func TestMagicLinkIsSingleUse(t *testing.T) {
+	cfg, mailer, _ := newTestConfig(t)
+	registerAndLogin(t, cfg, "magic@example.com", "magic-password")
+
+	rec := doJSON(t, http.HandlerFunc(cfg.MagicLinkHandler), "POST", "/api/login/magic", "", map[string]string{"email": "magic@example.com"})
+	if rec.Code != 202 {
+		t.Fatalf("Expected 202, got %d", rec.Code)
+	}
+
+	token := magicLinkToken(t, waitForMessage(t, mailer, "magic@example.com", "Your Chirpy login link"))
+
+	login := http.HandlerFunc(cfg.MagicLinkLoginHandler)
+
+	rec = doJSON(t, login, "POST", "/api/login/magic/verify", "", map[string]string{"token": token})
+	if rec.Code != 200 {
+		t.Fatalf("First use: expected 200, got %d", rec.Code)
+	}
+
+	session := testSession{}
+	decodeJSON(t, rec, &session)
+	if session.Token == "" || session.RefreshToken == "" {
+		t.Fatalf("Magic link login returned no tokens")
+	}
+
+	rec = doJSON(t, login, "POST", "/api/login/magic/verify", "", map[string]string{"token": token})
+	if rec.Code != 401 {
+		t.Fatalf("Consumed token: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestMagicLinkRejectsExpiredToken(t *testing.T) {
+	cfg := &ApiConfig{TokenSecret: testTokenSecret}
+
+	token, err := auth.MakeSignedToken(auth.SignedToken{
+		ID:        uuid.NewString(),
+		Purpose:   auth.PurposeMagicLogin,
+		Subject:   uuid.NewString(),
+		Email:     "magic@example.com",
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	}, cfg.TokenSecret)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.MagicLinkLoginHandler), "POST", "/api/login/magic/verify", "", map[string]string{"token": token})
+	if rec.Code != 401 {
+		t.Fatalf("Expired token: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestMagicLinkSharesEmailRateLimit(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	registerAndLogin(t, cfg, "limited@example.com", "limited-password")
+
+	// Reset and login links count against the same limit on an address
+	for range emailRequestLimit.FreeAttempts {
+		rec := doJSON(t, http.HandlerFunc(cfg.ForgotPasswordHandler), "POST", "/api/password/forgot", "", map[string]string{"email": "limited@example.com"})
+		if rec.Code != 202 {
+			t.Fatalf("Password reset request: expected 202, got %d", rec.Code)
+		}
+	}
+
+	rec := doJSON(t, http.HandlerFunc(cfg.MagicLinkHandler), "POST", "/api/login/magic", "", map[string]string{"email": "limited@example.com"})
+	if rec.Code != 429 {
+		t.Fatalf("Magic link over the limit: expected 429, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"context"
+	"log"
+	"time"
+)
+
+// Emails anyone can ask for, such as password reset links, are limited per
+// address and per IP so the endpoints can't be used to flood an inbox or to
+// send mail in bulk. The address is limited whether or not it has an
+// account, so the limit says nothing about which accounts exist.
+var emailRequestLimit = auth.LockoutPolicy{
+	FreeAttempts: 5,
+	BaseLockout:  15 * time.Minute,
+	MaxLockout:   24 * time.Hour,
+	Window:       time.Hour,
+}
+
+var ipEmailRequestLimit = auth.LockoutPolicy{
+	FreeAttempts: 20,
+	BaseLockout:  15 * time.Minute,
+	MaxLockout:   24 * time.Hour,
+	Window:       time.Hour,
+}
+
+// maxPendingEmails is how many requested emails may be on their way at once.
+// Requests past it are dropped rather than queued without bound.
+const maxPendingEmails = 16
+
+// pendingEmailTimeout bounds how long one requested email can hold its slot.
+const pendingEmailTimeout = 30 * time.Second
+
+// limitEmailRequest counts a request for an email to email from ip. It
+// returns how much longer such requests are refused, or zero if this one may
+// go ahead.
+func (cfg *ApiConfig) limitEmailRequest(ctx context.Context, email, ip string) (time.Duration, error) {
+	policies := map[string]auth.LockoutPolicy{
+		"mail:" + accountLockoutKey(email): emailRequestLimit,
+		"mail:" + ipLockoutKey(ip):         ipEmailRequestLimit,
+	}
+
+	keys := []string{}
+	for key := range policies {
+		keys = append(keys, key)
+	}
+
+	remaining, err := cfg.loginLockedOut(ctx, keys...)
+	if err != nil || remaining > 0 {
+		return remaining, err
+	}
+
+	cfg.recordAttempts(ctx, policies)
+	return 0, nil
+}
+
+// sendInBackground runs send after the response has gone, so that its timing
+// doesn't reveal whether the account exists. At most maxPendingEmails run at
+// once; it reports false if send was dropped.
+func (cfg *ApiConfig) sendInBackground(send func(ctx context.Context)) bool {
+	cfg.pendingEmailsOnce.Do(func() {
+		cfg.pendingEmails = make(chan struct{}, maxPendingEmails)
+	})
+
+	select {
+	case cfg.pendingEmails <- struct{}{}:
+	default:
+		log.Printf("Dropping email request: %d already pending", maxPendingEmails)
+		return false
+	}
+
+	go func() {
+		defer func() { <-cfg.pendingEmails }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), pendingEmailTimeout)
+		defer cancel()
+
+		send(ctx)
+	}()
+
+	return true
+}
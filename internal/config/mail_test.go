@@ -0,0 +1,40 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSendInBackgroundIsBounded(t *testing.T) {
+	cfg := &ApiConfig{}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	for i := range maxPendingEmails {
+		if !cfg.sendInBackground(func(ctx context.Context) {
+			started <- struct{}{}
+			<-release
+		}) {
+			t.Fatalf("Send %d dropped with room to spare", i+1)
+		}
+	}
+	for range maxPendingEmails {
+		<-started
+	}
+
+	if cfg.sendInBackground(func(ctx context.Context) {}) {
+		t.Fatalf("Send accepted with %d already pending", maxPendingEmails)
+	}
+
+	// Slots free up as the pending sends finish
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cfg.sendInBackground(func(ctx context.Context) {}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("No send accepted after the pending ones finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -41,6 +41,7 @@ func (cfg *ApiConfig) ResetMetricsHandler(w http.ResponseWriter, r *http.Request
 	if os.Getenv("PLATFORM") == "dev" {
 		cfg.FileserverHits.Store(0)
 		cfg.Db.Reset(r.Context())
+		cfg.audit(r, auditEntry{Event: auditResetMetrics, Outcome: auditSuccess, ActorID: adminActor(r)})
 
 		w.WriteHeader(http.StatusOK)
 		_, err := w.Write([]byte("OK"))
@@ -48,6 +49,8 @@ func (cfg *ApiConfig) ResetMetricsHandler(w http.ResponseWriter, r *http.Request
 			fmt.Println(err.Error())
 		}
 	} else {
+		cfg.audit(r, auditEntry{Event: auditResetMetrics, Outcome: auditDenied, ActorID: adminActor(r), Details: "not a dev platform"})
+
 		w.WriteHeader(http.StatusForbidden)
 		_, err := w.Write([]byte("FORBIDDEN"))
 		if err != nil {
@@ -0,0 +1,412 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	mfaTokenLifetime  = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+func (cfg *ApiConfig) respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	mfaToken, err := auth.MakeJWT(user.ID, cfg.Keys, mfaTokenLifetime, auth.WithAudience(auth.MFATokenAudience))
+	if err != nil {
+		log.Printf("Error generating MFA token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type returnVals struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	respStruct := returnVals{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+// checkSecondFactor accepts either a TOTP code or one of the user's unused
+// recovery codes, consuming whichever was presented.
+func (cfg *ApiConfig) checkSecondFactor(ctx context.Context, user database.User, code, recoveryCode string) error {
+	if !user.TotpSecret.Valid {
+		return errors.New("user has no TOTP secret")
+	}
+
+	if code != "" {
+		step, err := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now(), user.TotpLastStep)
+		if err != nil {
+			return err
+		}
+
+		useParams := database.UseTOTPStepParams{
+			TotpLastStep: step,
+			ID:           user.ID,
+		}
+
+		rows, err := cfg.Db.UseTOTPStep(ctx, useParams)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errors.New("TOTP code already used")
+		}
+
+		return nil
+	}
+
+	if recoveryCode != "" {
+		useParams := database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode), cfg.TokenSecret),
+		}
+
+		rows, err := cfg.Db.UseRecoveryCode(ctx, useParams)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errors.New("invalid recovery code")
+		}
+
+		return nil
+	}
+
+	return errors.New("no second factor provided")
+}
+
+// secondFactorKind names the kind of code checkSecondFactor was given, for
+// the audit log.
+func secondFactorKind(recoveryCode string) string {
+	if recoveryCode != "" {
+		return "recovery code"
+	}
+	return "TOTP code"
+}
+
+func (cfg *ApiConfig) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceLabel  string `json:"device_label"`
+		UseCookies   bool   `json:"use_cookies"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	claims, err := auth.ParseJWT(params.MFAToken, cfg.Keys, auth.MFATokenAudience)
+	if err != nil {
+		log.Printf("Invalid MFA token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		log.Printf("Invalid MFA token subject: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	ip := cfg.clientIP(r)
+
+	remaining, err := cfg.loginLockedOut(r.Context(), accountLockoutKey(user.Email), ipLockoutKey(ip))
+	if err != nil {
+		log.Printf("Error checking login lockout: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if remaining > 0 {
+		log.Printf("MFA attempt while locked out")
+		respondLockedOut(w, remaining)
+		return
+	}
+
+	err = cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		cfg.recordLoginFailure(r.Context(), user.Email, ip)
+		log.Printf("Second factor rejected: %s", err)
+		cfg.audit(r, auditEntry{Event: auditSecondFactor, Outcome: auditFailure, SubjectID: user.ID, Details: secondFactorKind(params.RecoveryCode) + ": " + err.Error()})
+		w.WriteHeader(401)
+		return
+	}
+
+	cfg.clearLoginFailures(r.Context(), user.Email)
+
+	cfg.respondWithLogin(w, r, user, loginOptions{
+		ExpiresIn:   time.Hour,
+		DeviceLabel: params.DeviceLabel,
+		UseCookies:  params.UseCookies,
+	})
+}
+
+func (cfg *ApiConfig) TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		log.Printf("TOTP already enabled for user %s", user.ID)
+		w.WriteHeader(409)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	setParams := database.SetTOTPSecretParams{
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+		ID:         user.ID,
+	}
+
+	err = cfg.Db.SetTOTPSecret(r.Context(), setParams)
+	if err != nil {
+		log.Printf("Error storing TOTP secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type returnVals struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+
+	respStruct := returnVals{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		log.Printf("TOTP already enabled for user %s", user.ID)
+		w.WriteHeader(409)
+		return
+	}
+
+	if !user.TotpSecret.Valid {
+		log.Printf("TOTP confirmation without enrollment for user %s", user.ID)
+		w.WriteHeader(400)
+		return
+	}
+
+	step, err := auth.ValidateTOTP(user.TotpSecret.String, params.Code, time.Now(), 0)
+	if err != nil {
+		log.Printf("Invalid TOTP confirmation code: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	enableParams := database.EnableTOTPParams{
+		TotpLastStep: step,
+		ID:           user.ID,
+	}
+
+	err = cfg.Db.EnableTOTP(r.Context(), enableParams)
+	if err != nil {
+		log.Printf("Error enabling TOTP: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	recoveryCodes, err := cfg.replaceRecoveryCodes(r.Context(), user)
+	if err != nil {
+		log.Printf("Error creating recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type returnVals struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	respStruct := returnVals{
+		RecoveryCodes: recoveryCodes,
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	if !user.TotpEnabledAt.Valid {
+		w.WriteHeader(204)
+		return
+	}
+
+	err = cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode)
+	if err != nil {
+		log.Printf("Second factor rejected: %s", err)
+		w.WriteHeader(403)
+		return
+	}
+
+	err = cfg.Db.DisableTOTP(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error disabling TOTP: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = cfg.Db.DeleteRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error deleting recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) replaceRecoveryCodes(ctx context.Context, user database.User) ([]string, error) {
+	err := cfg.Db.DeleteRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		createParams := database.CreateRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code), cfg.TokenSecret),
+		}
+
+		err = cfg.Db.CreateRecoveryCode(ctx, createParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
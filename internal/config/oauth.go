@@ -0,0 +1,530 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	authorizationCodeLifetime = 5 * time.Minute
+	oauthAccessTokenLifetime  = time.Hour
+)
+
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsRead:   "Read your chirps",
+	auth.ScopeChirpsWrite:  "Post and delete chirps as you",
+	auth.ScopeProfileRead:  "See your email address and account details",
+	auth.ScopeProfileWrite: "Resend your email verification link",
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<html>
+
+<body>
+    <h1>Authorize {{.ClientName}}</h1>
+    <p>{{.ClientName}} would like to:</p>
+    <ul>
+        {{range .Scopes}}<li>{{.}}</li>
+        {{end}}
+    </ul>
+    {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+    <form method="POST" action="/oauth/authorize">
+        {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+        {{end}}
+        <p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+        <p><label>Password <input type="password" name="password" required></label></p>
+        <p><label>Authenticator code, if enabled <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+        <button type="submit" name="decision" value="allow">Allow</button>
+        <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+</body>
+
+</html>`))
+
+// oauthError is reported to the client, by redirect from the authorization
+// endpoint or as a JSON body from the token endpoint.
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e oauthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+type authorizationRequest struct {
+	Client              database.OauthClient
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// parseAuthorizationRequest returns an oauthError for problems that can be
+// sent back to the client. Any other error means the client or redirect URI
+// can't be trusted and the user must not be redirected.
+func (cfg *ApiConfig) parseAuthorizationRequest(ctx context.Context, values url.Values) (authorizationRequest, error) {
+	client, err := cfg.Db.GetOAuthClient(ctx, values.Get("client_id"))
+	if err != nil {
+		return authorizationRequest{}, fmt.Errorf("unknown client: %w", err)
+	}
+
+	redirectURI := values.Get("redirect_uri")
+	if !slices.Contains(strings.Fields(client.RedirectUris), redirectURI) {
+		return authorizationRequest{}, fmt.Errorf("redirect URI %q not registered for client %s", redirectURI, client.ID)
+	}
+
+	req := authorizationRequest{
+		Client:              client,
+		RedirectURI:         redirectURI,
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+
+	if values.Get("response_type") != "code" {
+		return req, oauthError{"unsupported_response_type", "only the authorization code flow is supported"}
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != auth.CodeChallengeMethodS256 {
+		return req, oauthError{"invalid_request", "PKCE with code_challenge_method S256 is required"}
+	}
+
+	allowed := auth.ParseScopes(client.Scopes)
+	req.Scopes = allowed
+	if requested := values.Get("scope"); requested != "" {
+		scopes, err := auth.ValidateScopes(auth.ParseScopes(requested))
+		if err != nil {
+			return req, oauthError{"invalid_scope", err.Error()}
+		}
+		for _, scope := range scopes {
+			if !auth.HasScope(allowed, scope) {
+				return req, oauthError{"invalid_scope", "client is not registered for " + scope}
+			}
+		}
+		req.Scopes = scopes
+	}
+
+	return req, nil
+}
+
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizationRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		log.Printf("Error parsing redirect URI: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func respondWithAuthorizationError(w http.ResponseWriter, r *http.Request, req authorizationRequest, err error) {
+	var oauthErr oauthError
+	if !errors.As(err, &oauthErr) {
+		log.Printf("Invalid authorization request: %s", err)
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid authorization request"))
+		return
+	}
+
+	log.Printf("Authorization request rejected: %s", err)
+	redirectToClient(w, r, req, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+	})
+}
+
+func renderConsent(w http.ResponseWriter, req authorizationRequest, email, message string, status int) {
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+
+	data := struct {
+		ClientName string
+		Scopes     []string
+		Error      string
+		Email      string
+		Params     map[string]string
+	}{
+		ClientName: req.Client.Name,
+		Scopes:     scopes,
+		Error:      message,
+		Email:      email,
+		Params: map[string]string{
+			"response_type":         "code",
+			"client_id":             req.Client.ID,
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 auth.FormatScopes(req.Scopes),
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": req.CodeChallengeMethod,
+		},
+	}
+
+	// The consent page must never be framed by the client asking for access
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := consentTemplate.Execute(w, data)
+	if err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+func (cfg *ApiConfig) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := cfg.parseAuthorizationRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		respondWithAuthorizationError(w, r, req, err)
+		return
+	}
+
+	renderConsent(w, req, "", "", 200)
+}
+
+// AuthorizeConsentHandler signs the user in and, if they allow it, sends the
+// client back an authorization code. The password on the form also stops
+// other sites from submitting consent on the user's behalf.
+func (cfg *ApiConfig) AuthorizeConsentHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Error parsing form: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	req, err := cfg.parseAuthorizationRequest(r.Context(), r.PostForm)
+	if err != nil {
+		respondWithAuthorizationError(w, r, req, err)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	ip := cfg.clientIP(r)
+
+	user, remaining, err := cfg.checkPassword(r.Context(), email, r.PostForm.Get("password"), ip)
+	if errors.Is(err, errLockedOut) {
+		log.Printf("OAuth consent while locked out")
+		message := fmt.Sprintf("Too many failed attempts. Try again in %s.", remaining.Round(time.Second))
+		renderConsent(w, req, email, message, 429)
+		return
+	}
+	if errors.Is(err, errBadCredentials) {
+		log.Printf("Wrong login or password: %s", err)
+		renderConsent(w, req, email, "Wrong email or password.", 401)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if user.SuspendedAt.Valid {
+		log.Printf("OAuth consent by suspended user %s", user.ID)
+		redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		err = cfg.checkSecondFactor(r.Context(), user, r.PostForm.Get("code"), "")
+		if err != nil {
+			cfg.recordLoginFailure(r.Context(), email, ip)
+			log.Printf("Invalid second factor: %s", err)
+			cfg.audit(r, auditEntry{Event: auditSecondFactor, Outcome: auditFailure, SubjectID: user.ID, Details: secondFactorKind("") + ": " + err.Error()})
+			renderConsent(w, req, email, "Enter a valid code from your authenticator app.", 401)
+			return
+		}
+
+		cfg.clearLoginFailures(r.Context(), email)
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating authorization code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	codeParams := database.CreateAuthorizationCodeParams{
+		CodeHash:            auth.HashToken(code, cfg.TokenSecret),
+		ClientID:            req.Client.ID,
+		UserID:              user.ID,
+		RedirectUri:         req.RedirectURI,
+		Scopes:              auth.FormatScopes(req.Scopes),
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ExpiresAt:           time.Now().UTC().Add(authorizationCodeLifetime),
+	}
+
+	err = cfg.Db.CreateAuthorizationCode(r.Context(), codeParams)
+	if err != nil {
+		log.Printf("Error storing authorization code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+func respondWithOAuthError(w http.ResponseWriter, status int, err oauthError) {
+	type returnVals struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+
+	dat, marshalErr := json.Marshal(returnVals{Error: err.Code, Description: err.Description})
+	if marshalErr != nil {
+		log.Printf("Error marshalling JSON: %s", marshalErr)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Error parsing form: %s", err)
+		respondWithOAuthError(w, 400, oauthError{"invalid_request", "malformed form body"})
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		log.Printf("Error authenticating client: %s", err)
+		respondWithOAuthError(w, 401, oauthError{"invalid_client", ""})
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.exchangeOAuthRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, 400, oauthError{"unsupported_grant_type", ""})
+	}
+}
+
+func (cfg *ApiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	codeHash := auth.HashToken(r.PostForm.Get("code"), cfg.TokenSecret)
+
+	code, err := cfg.Db.ConsumeAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// A code presented twice may have been intercepted, so anything
+		// issued from it is no longer trusted
+		used, lookupErr := cfg.Db.GetAuthorizationCode(r.Context(), codeHash)
+		if lookupErr == nil && used.UsedAt.Valid {
+			log.Printf("Authorization code reuse detected for client %s, revoking grant", used.ClientID)
+			revokeErr := cfg.Db.RevokeOAuthGrant(r.Context(), codeHash)
+			if revokeErr != nil {
+				log.Printf("Error revoking OAuth grant: %s", revokeErr)
+			}
+		}
+		respondWithOAuthError(w, 400, oauthError{"invalid_grant", "authorization code is invalid or expired"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error consuming authorization code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+		log.Printf("Authorization code presented by wrong client or redirect URI")
+		respondWithOAuthError(w, 400, oauthError{"invalid_grant", "authorization code was not issued to this client"})
+		return
+	}
+
+	err = auth.VerifyCodeChallenge(r.PostForm.Get("code_verifier"), code.CodeChallenge, code.CodeChallengeMethod)
+	if err != nil {
+		log.Printf("PKCE verification failed: %s", err)
+		respondWithOAuthError(w, 400, oauthError{"invalid_grant", "code verifier does not match"})
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, r, client.ID, code.UserID, codeHash, auth.ParseScopes(code.Scopes))
+}
+
+func (cfg *ApiConfig) exchangeOAuthRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	rotateParams := database.RotateOAuthRefreshTokenParams{
+		TokenHash: auth.HashToken(r.PostForm.Get("refresh_token"), cfg.TokenSecret),
+		ClientID:  client.ID,
+	}
+
+	current, err := cfg.Db.RotateOAuthRefreshToken(r.Context(), rotateParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithOAuthError(w, 400, oauthError{"invalid_grant", "refresh token is invalid or expired"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating OAuth refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// A client may ask for a narrower scope, never a wider one
+	scopes := auth.ParseScopes(current.Scopes)
+	if requested := r.PostForm.Get("scope"); requested != "" {
+		narrowed := auth.ParseScopes(requested)
+		for _, scope := range narrowed {
+			if !auth.HasScope(scopes, scope) {
+				respondWithOAuthError(w, 400, oauthError{"invalid_scope", scope + " was not granted"})
+				return
+			}
+		}
+		scopes = narrowed
+	}
+
+	cfg.respondWithOAuthTokens(w, r, client.ID, current.UserID, current.CodeHash, scopes)
+}
+
+// respondWithOAuthTokens issues a scoped access token and a new refresh token
+// belonging to the grant started by the authorization code codeHash.
+func (cfg *ApiConfig) respondWithOAuthTokens(w http.ResponseWriter, r *http.Request, clientID string, userID uuid.UUID, codeHash string, scopes []string) {
+	user, err := cfg.Db.GetUserByID(r.Context(), userID)
+	if err != nil || user.SuspendedAt.Valid {
+		log.Printf("OAuth grant for missing or suspended user %s", userID)
+		respondWithOAuthError(w, 400, oauthError{"invalid_grant", "user is not allowed to sign in"})
+		return
+	}
+
+	accessToken, err := auth.MakeJWT(user.ID, cfg.Keys, oauthAccessTokenLifetime, auth.WithClient(clientID, scopes))
+	if err != nil {
+		log.Printf("Error generating access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	rawRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	refreshTokenParams := database.CreateOAuthRefreshTokenParams{
+		TokenHash:   auth.HashToken(rawRefreshToken, cfg.TokenSecret),
+		TokenPrefix: auth.TokenPrefix(rawRefreshToken),
+		ClientID:    clientID,
+		UserID:      userID,
+		CodeHash:    codeHash,
+		Scopes:      auth.FormatScopes(scopes),
+	}
+
+	_, err = cfg.Db.CreateOAuthRefreshToken(r.Context(), refreshTokenParams)
+	if err != nil {
+		log.Printf("Error inserting OAuth refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type returnVals struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	respStruct := returnVals{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenLifetime.Seconds()),
+		RefreshToken: rawRefreshToken,
+		Scope:        auth.FormatScopes(scopes),
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+// OAuthRevokeHandler implements RFC 7009 for refresh tokens. Revoking one also
+// revokes the access tokens the client already holds for that user.
+func (cfg *ApiConfig) OAuthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Error parsing form: %s", err)
+		respondWithOAuthError(w, 400, oauthError{"invalid_request", "malformed form body"})
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		log.Printf("Error authenticating client: %s", err)
+		respondWithOAuthError(w, 401, oauthError{"invalid_client", ""})
+		return
+	}
+
+	revokeParams := database.RevokeOAuthRefreshTokenParams{
+		TokenHash: auth.HashToken(r.PostForm.Get("token"), cfg.TokenSecret),
+		ClientID:  client.ID,
+	}
+
+	// Unknown tokens still get a 200 so clients can't probe for valid ones
+	userID, err := cfg.Db.RevokeOAuthRefreshToken(r.Context(), revokeParams)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(200)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking OAuth refresh token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = cfg.revokeAccessTokens(r.Context(), auth.RevokedGrantKey(userID, client.ID))
+	if err != nil {
+		log.Printf("Error revoking OAuth access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type oauthClientResponse struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+func newOAuthClientResponse(client database.OauthClient) oauthClientResponse {
+	return oauthClientResponse{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		RedirectURIs: strings.Fields(client.RedirectUris),
+		Scopes:       auth.ParseScopes(client.Scopes),
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// Public clients (native and browser apps) can't keep a secret, so they are
+// registered without one and rely on PKCE alone.
+func (cfg *ApiConfig) CreateOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	scopes, err := auth.ValidateScopes(params.Scopes)
+	if err != nil || len(scopes) == 0 || params.Name == "" || len(params.RedirectURIs) == 0 {
+		log.Printf("Invalid OAuth client request: %v", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	for _, uri := range params.RedirectURIs {
+		err = auth.ValidateRedirectURI(uri)
+		if err != nil {
+			log.Printf("Invalid redirect URI %q: %s", uri, err)
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	createParams := database.CreateOAuthClientParams{
+		ID:           uuid.NewString(),
+		OwnerID:      caller.UserID,
+		Name:         params.Name,
+		RedirectUris: strings.Join(params.RedirectURIs, " "),
+		Scopes:       auth.FormatScopes(scopes),
+	}
+
+	secret := ""
+	if params.Confidential {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			log.Printf("Error generating client secret: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		createParams.SecretHash = sql.NullString{String: auth.HashToken(secret, cfg.TokenSecret), Valid: true}
+	}
+
+	client, err := cfg.Db.CreateOAuthClient(r.Context(), createParams)
+	if err != nil {
+		log.Printf("Error creating OAuth client: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	respStruct := newOAuthClientResponse(client)
+	respStruct.ClientSecret = secret
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) OAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	clients, err := cfg.Db.GetUserOAuthClients(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("Error retrieving OAuth clients: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	respStruct := []oauthClientResponse{}
+	for _, client := range clients {
+		respStruct = append(respStruct, newOAuthClientResponse(client))
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) DeleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	deleteParams := database.DeleteOAuthClientParams{
+		ID:      r.PathValue("clientID"),
+		OwnerID: caller.UserID,
+	}
+
+	rows, err := cfg.Db.DeleteOAuthClient(r.Context(), deleteParams)
+	if err != nil {
+		log.Printf("Error deleting OAuth client: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	// Deleting the client deleted its refresh tokens; its access tokens go too
+	err = cfg.revokeAccessTokens(r.Context(), auth.RevokedClientKey(deleteParams.ID))
+	if err != nil {
+		log.Printf("Error revoking OAuth client access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+var errInvalidClient = errors.New("invalid client credentials")
+
+// authenticateClient reads client credentials from HTTP Basic auth or, failing
+// that, the form body. Public clients send only their client_id.
+func (cfg *ApiConfig) authenticateClient(r *http.Request) (database.OauthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.Db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, errInvalidClient
+	}
+
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, errInvalidClient
+		}
+		return client, nil
+	}
+
+	hashed := auth.HashToken(secret, cfg.TokenSecret)
+	if subtle.ConstantTimeCompare([]byte(hashed), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, errInvalidClient
+	}
+
+	return client, nil
+}
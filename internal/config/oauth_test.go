@@ -0,0 +1,180 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testRedirectURI = "http://localhost:9000/callback"
+
+func newOAuthTestMux(cfg *ApiConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/oauth/clients", http.HandlerFunc(cfg.CreateOAuthClientHandler))
+	mux.Handle("POST /api/oauth/introspect", http.HandlerFunc(cfg.IntrospectHandler))
+	mux.Handle("GET /oauth/authorize", http.HandlerFunc(cfg.AuthorizeHandler))
+	mux.Handle("POST /oauth/authorize", http.HandlerFunc(cfg.AuthorizeConsentHandler))
+	mux.Handle("POST /oauth/token", http.HandlerFunc(cfg.TokenHandler))
+	mux.Handle("POST /oauth/revoke", http.HandlerFunc(cfg.OAuthRevokeHandler))
+	return mux
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// authorizeTestClient registers a public client for the logged in user and
+// runs it through the authorization code flow up to its first tokens.
+func authorizeTestClient(t *testing.T, mux http.Handler, session testSession, email, password string) (string, oauthTokenResponse) {
+	t.Helper()
+
+	rec := doJSON(t, mux, "POST", "/api/oauth/clients", session.Token, map[string]any{
+		"name":          "Test App",
+		"redirect_uris": []string{testRedirectURI},
+		"scopes":        []string{auth.ScopeChirpsRead, auth.ScopeProfileRead},
+	})
+	if rec.Code != 201 {
+		t.Fatalf("Registering client: expected 201, got %d", rec.Code)
+	}
+
+	client := oauthClientResponse{}
+	decodeJSON(t, rec, &client)
+
+	verifier, err := auth.MakeCodeVerifier()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	authorizeParams := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {auth.ScopeChirpsRead},
+		"state":                 {"xyz"},
+		"code_challenge":        {auth.CodeChallengeS256(verifier)},
+		"code_challenge_method": {auth.CodeChallengeMethodS256},
+	}
+
+	rec = doJSON(t, mux, "GET", "/oauth/authorize?"+authorizeParams.Encode(), "", nil)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "Test App") {
+		t.Fatalf("Authorize: expected consent page, got %d", rec.Code)
+	}
+
+	consent := url.Values{}
+	for name, values := range authorizeParams {
+		consent[name] = values
+	}
+	consent.Set("email", email)
+	consent.Set("password", password)
+	consent.Set("decision", "allow")
+
+	rec = doForm(t, mux, "/oauth/authorize", "", consent)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Consent: expected 303, got %d", rec.Code)
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if location.Query().Get("state") != "xyz" || location.Query().Get("code") == "" {
+		t.Fatalf("Consent redirect %s is missing code or state", location)
+	}
+
+	rec = doForm(t, mux, "/oauth/token", "", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {client.ClientID},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	})
+	if rec.Code != 200 {
+		t.Fatalf("Code exchange: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	issued := oauthTokenResponse{}
+	decodeJSON(t, rec, &issued)
+	if issued.Scope != auth.ScopeChirpsRead {
+		t.Fatalf("Expected scope %s, got %s", auth.ScopeChirpsRead, issued.Scope)
+	}
+
+	return client.ClientID, issued
+}
+
+// refreshOAuthToken returns nil if the token endpoint refuses refreshToken.
+func refreshOAuthToken(t *testing.T, mux http.Handler, clientID, refreshToken string) *oauthTokenResponse {
+	t.Helper()
+
+	rec := doForm(t, mux, "/oauth/token", "", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {clientID},
+		"refresh_token": {refreshToken},
+	})
+	if rec.Code != 200 {
+		return nil
+	}
+
+	refreshed := oauthTokenResponse{}
+	decodeJSON(t, rec, &refreshed)
+	return &refreshed
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	owner := registerAndLogin(t, cfg, "owner@example.com", "owner-password")
+	mux := newOAuthTestMux(cfg)
+
+	clientID, issued := authorizeTestClient(t, mux, owner, "owner@example.com", "owner-password")
+
+	active := introspectToken(t, mux, issued.AccessToken)
+	if !active.Active || active.ClientID != clientID || active.Subject != owner.UserID.String() {
+		t.Fatalf("Access token introspected as %+v", active)
+	}
+
+	refreshed := refreshOAuthToken(t, mux, clientID, issued.RefreshToken)
+	if refreshed == nil || refreshed.RefreshToken == issued.RefreshToken {
+		t.Fatalf("Refresh did not rotate the refresh token")
+	}
+
+	if refreshOAuthToken(t, mux, clientID, issued.RefreshToken) != nil {
+		t.Fatalf("Rotated refresh token was accepted")
+	}
+
+	rec := doForm(t, mux, "/oauth/revoke", "", url.Values{
+		"client_id": {clientID},
+		"token":     {refreshed.RefreshToken},
+	})
+	if rec.Code != 200 {
+		t.Fatalf("Revoke: expected 200, got %d", rec.Code)
+	}
+
+	if refreshOAuthToken(t, mux, clientID, refreshed.RefreshToken) != nil {
+		t.Fatalf("Revoked refresh token was accepted")
+	}
+
+	if introspectToken(t, mux, refreshed.AccessToken).Active {
+		t.Fatalf("Access token is still active after its grant was revoked")
+	}
+}
+
+func TestDeleteOAuthClientRevokesAccessTokens(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	owner := registerAndLogin(t, cfg, "owner@example.com", "owner-password")
+	mux := newOAuthTestMux(cfg)
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", http.HandlerFunc(cfg.DeleteOAuthClientHandler))
+
+	clientID, issued := authorizeTestClient(t, mux, owner, "owner@example.com", "owner-password")
+
+	rec := doJSON(t, mux, "DELETE", "/api/oauth/clients/"+clientID, owner.Token, nil)
+	if rec.Code != 204 {
+		t.Fatalf("Deleting client: expected 204, got %d", rec.Code)
+	}
+
+	if introspectToken(t, mux, issued.AccessToken).Active {
+		t.Fatalf("Access token is still active after its client was deleted")
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const oidcStateCookie = "chirpy_oidc_state"
+
+// OIDCLoginHandler sends the user to the SSO provider. The state is kept both
+// in the database and in a cookie so the callback can only complete in the
+// browser that started the login.
+func (cfg *ApiConfig) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.OIDC == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating OIDC state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating OIDC nonce: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	verifier, err := auth.MakeCodeVerifier()
+	if err != nil {
+		log.Printf("Error generating PKCE verifier: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	stateParams := database.CreateOIDCLoginStateParams{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}
+
+	err = cfg.Db.CreateOIDCLoginState(r.Context(), stateParams)
+	if err != nil {
+		log.Printf("Error storing OIDC state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/login/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, cfg.OIDC.AuthCodeURL(state, nonce, auth.CodeChallengeS256(verifier)), http.StatusFound)
+}
+
+func (cfg *ApiConfig) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.OIDC == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC provider returned error: %s", providerErr)
+		w.WriteHeader(401)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value != query.Get("state") {
+		log.Printf("OIDC state does not match cookie")
+		w.WriteHeader(400)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/login/oidc", MaxAge: -1})
+
+	loginState, err := cfg.Db.ConsumeOIDCLoginState(r.Context(), cookie.Value)
+	if err != nil {
+		log.Printf("Unknown or expired OIDC state: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	err = cfg.Db.DeleteExpiredOIDCLoginStates(r.Context())
+	if err != nil {
+		log.Printf("Error deleting expired OIDC states: %s", err)
+	}
+
+	claims, err := cfg.OIDC.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Printf("Error completing OIDC login: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.oidcUser(r.Context(), claims)
+	if err != nil {
+		log.Printf("Error finding user for OIDC login: %s", err)
+		w.WriteHeader(403)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user, loginOptions{ExpiresIn: time.Hour})
+}
+
+// oidcUser finds the user an SSO identity belongs to. The first time an
+// identity is seen it is linked to the user with the same email, or a new
+// passwordless user is created, but only if the provider vouches for the
+// email address.
+func (cfg *ApiConfig) oidcUser(ctx context.Context, claims auth.IDTokenClaims) (database.User, error) {
+	identityParams := database.GetUserByIdentityParams{
+		Issuer:  cfg.OIDC.Issuer,
+		Subject: claims.Subject,
+	}
+
+	user, err := cfg.Db.GetUserByIdentity(ctx, identityParams)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, fmt.Errorf("provider has not verified email for subject %s", claims.Subject)
+	}
+
+	user, err = cfg.Db.GetUser(ctx, claims.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// No local password: it can be set later with a password reset
+		createUserParams := database.CreateUserParams{
+			Email:          claims.Email,
+			HashedPassword: "",
+		}
+
+		user, err = cfg.Db.CreateUser(ctx, createUserParams)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if !user.EmailVerifiedAt.Valid {
+		verifyParams := database.VerifyEmailParams{
+			ID:    user.ID,
+			Email: claims.Email,
+		}
+
+		_, err = cfg.Db.VerifyEmail(ctx, verifyParams)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+
+	createIdentityParams := database.CreateUserIdentityParams{
+		UserID:  user.ID,
+		Issuer:  cfg.OIDC.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+
+	err = cfg.Db.CreateUserIdentity(ctx, createIdentityParams)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	log.Printf("Linked %s identity %s to user %s", cfg.OIDC.Issuer, claims.Subject, user.ID)
+	return user, nil
+}
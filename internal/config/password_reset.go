@@ -0,0 +1,163 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/mail"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+func (cfg *ApiConfig) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	remaining, err := cfg.limitEmailRequest(r.Context(), params.Email, cfg.clientIP(r))
+	if err != nil {
+		log.Printf("Error checking email rate limit: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if remaining > 0 {
+		log.Printf("Password reset requests rate limited")
+		respondLockedOut(w, remaining)
+		return
+	}
+
+	// Answer the same way, and as quickly, whether or not the account
+	// exists so this endpoint can't be used to enumerate emails.
+	cfg.sendInBackground(func(ctx context.Context) {
+		cfg.sendPasswordReset(ctx, params.Email)
+	})
+
+	w.WriteHeader(202)
+}
+
+func (cfg *ApiConfig) sendPasswordReset(ctx context.Context, email string) {
+	user, err := cfg.Db.GetUser(ctx, email)
+	if err != nil {
+		log.Printf("Password reset requested for unknown email: %s", err)
+		return
+	}
+
+	rawToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating password reset token: %s", err)
+		return
+	}
+
+	createParams := database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(rawToken, cfg.TokenSecret),
+		UserID:    user.ID,
+	}
+
+	err = cfg.Db.CreatePasswordResetToken(ctx, createParams)
+	if err != nil {
+		log.Printf("Error storing password reset token: %s", err)
+		return
+	}
+
+	link := cfg.BaseURL + "/app/reset-password?token=" + url.QueryEscape(rawToken)
+
+	err = cfg.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    fmt.Sprintf("Someone asked to reset the password for this account. If it was you, open the link below within an hour. If not, you can ignore this email.\n\n%s\n", link),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email: %s", err)
+	}
+}
+
+func (cfg *ApiConfig) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if params.Password == "" {
+		log.Printf("Password reset with empty password")
+		w.WriteHeader(400)
+		return
+	}
+
+	userID, err := cfg.Db.ConsumePasswordResetToken(r.Context(), auth.HashToken(params.Token, cfg.TokenSecret))
+	if err != nil {
+		log.Printf("Invalid password reset token: %s", err)
+		cfg.audit(r, auditEntry{Event: auditPasswordReset, Outcome: auditFailure, Details: "invalid or expired token"})
+		w.WriteHeader(400)
+		return
+	}
+
+	hashedPass, err := cfg.Passwords.Hash(params.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	updateParams := database.UpdateUserPasswordParams{
+		HashedPassword: hashedPass,
+		ID:             userID,
+	}
+
+	err = cfg.Db.UpdateUserPassword(r.Context(), updateParams)
+	if err != nil {
+		log.Printf("Error updating password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = cfg.Db.InvalidatePasswordResetTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error invalidating password reset tokens: %s", err)
+	}
+
+	err = cfg.Db.RevokeUserRefreshTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = cfg.Db.RevokeUserOAuthGrants(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking OAuth grants: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = cfg.revokeAccessTokens(r.Context(), auth.RevokedUserKey(userID))
+	if err != nil {
+		log.Printf("Error revoking access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditPasswordReset, Outcome: auditSuccess, ActorID: userID, SubjectID: userID})
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestForgotPasswordIsRateLimited(t *testing.T) {
+	cfg, mailer, _ := newTestConfig(t)
+	registerAndLogin(t, cfg, "forgot@example.com", "forgot-password")
+	forgot := http.HandlerFunc(cfg.ForgotPasswordHandler)
+
+	for i := range emailRequestLimit.FreeAttempts {
+		rec := doJSON(t, forgot, "POST", "/api/password/forgot", "", map[string]string{"email": "forgot@example.com"})
+		if rec.Code != 202 {
+			t.Fatalf("Request %d: expected 202, got %d", i+1, rec.Code)
+		}
+	}
+	waitForMessage(t, mailer, "forgot@example.com", "Reset your Chirpy password")
+
+	rec := doJSON(t, forgot, "POST", "/api/password/forgot", "", map[string]string{"email": "forgot@example.com"})
+	if rec.Code != 429 || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("Request over the limit: expected 429 with Retry-After, got %d", rec.Code)
+	}
+
+	// Unknown addresses are limited the same way, so the limit reveals nothing
+	for range emailRequestLimit.FreeAttempts {
+		doJSON(t, forgot, "POST", "/api/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	}
+	rec = doJSON(t, forgot, "POST", "/api/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	if rec.Code != 429 {
+		t.Fatalf("Unknown address over the limit: expected 429, got %d", rec.Code)
+	}
+}
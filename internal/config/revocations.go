@@ -0,0 +1,69 @@
+package config
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"log"
+	"time"
+)
+
+// revokeAccessTokens revokes the access tokens named by key (see
+// auth.RevokedTokenKey and friends). It takes effect here immediately and on
+// other instances at their next sync.
+func (cfg *ApiConfig) revokeAccessTokens(ctx context.Context, key string) error {
+	now := time.Now().UTC()
+
+	revokeParams := database.RevokeAccessTokensParams{
+		Key:       key,
+		RevokedAt: now,
+		ExpiresAt: now.Add(maxAccessTokenLifetime),
+	}
+
+	err := cfg.Db.RevokeAccessTokens(ctx, revokeParams)
+	if err != nil {
+		return err
+	}
+
+	cfg.Revocations.Add(key, now)
+	return nil
+}
+
+func (cfg *ApiConfig) LoadRevocations(ctx context.Context) error {
+	rows, err := cfg.Db.GetAccessTokenRevocations(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]time.Time{}
+	for _, row := range rows {
+		entries[row.Key] = row.RevokedAt
+	}
+
+	cfg.Revocations.Replace(entries)
+	return nil
+}
+
+// SyncRevocationsEvery reloads revocations made by other instances and drops
+// those that only cover tokens which have expired anyway.
+func (cfg *ApiConfig) SyncRevocationsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := cfg.Db.DeleteExpiredAccessTokenRevocations(ctx)
+		if err != nil {
+			log.Printf("Error deleting expired revocations: %s", err)
+		}
+
+		err = cfg.LoadRevocations(ctx)
+		if err != nil {
+			log.Printf("Error loading revocations: %s", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A session is a refresh token family: it starts at login and survives every
+// rotation until it is revoked or expires.
+func (cfg *ApiConfig) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	sessions, err := cfg.Db.GetUserSessions(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("Error retrieving sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type session struct {
+		ID          uuid.UUID `json:"id"`
+		DeviceLabel string    `json:"device_label"`
+		UserAgent   string    `json:"user_agent"`
+		IP          string    `json:"ip"`
+		StartedAt   time.Time `json:"started_at"`
+		LastUsedAt  time.Time `json:"last_used_at"`
+		ExpiresAt   time.Time `json:"expires_at"`
+		Current     bool      `json:"current"`
+	}
+
+	respStruct := []session{}
+	for _, row := range sessions {
+		respStruct = append(respStruct, session{
+			ID:          row.FamilyID,
+			DeviceLabel: row.DeviceLabel,
+			UserAgent:   row.UserAgent,
+			IP:          row.Ip,
+			StartedAt:   row.StartedAt,
+			LastUsedAt:  row.LastUsedAt,
+			ExpiresAt:   row.ExpiresAt,
+			Current:     row.FamilyID.String() == caller.SessionID,
+		})
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		log.Printf("Invalid session ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	revokeParams := database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   caller.UserID,
+	}
+
+	rows, err := cfg.Db.RevokeSession(r.Context(), revokeParams)
+	if err != nil {
+		log.Printf("Error revoking session: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.revokeAccessTokens(r.Context(), auth.RevokedSessionKey(sessionID))
+	if err != nil {
+		log.Printf("Error revoking access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditSessionRevoke, Outcome: auditSuccess, ActorID: caller.UserID, SubjectID: caller.UserID, Details: "session " + sessionID.String()})
+
+	w.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	sessionID, err := uuid.Parse(caller.SessionID)
+	if err != nil {
+		log.Printf("Access token is not tied to a session: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	err = cfg.revokeOtherSessions(r.Context(), caller.UserID, sessionID)
+	if err != nil {
+		log.Printf("Error revoking sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditSessionRevoke, Outcome: auditSuccess, ActorID: caller.UserID, SubjectID: caller.UserID, Details: "all sessions but " + sessionID.String()})
+
+	w.WriteHeader(204)
+}
+
+// revokeOtherSessions logs the user out of every session but sessionID,
+// including the access tokens those sessions already hold.
+func (cfg *ApiConfig) revokeOtherSessions(ctx context.Context, userID, sessionID uuid.UUID) error {
+	sessions, err := cfg.Db.GetUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	revokeParams := database.RevokeOtherSessionsParams{
+		UserID:   userID,
+		FamilyID: sessionID,
+	}
+
+	err = cfg.Db.RevokeOtherSessions(ctx, revokeParams)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.FamilyID == sessionID {
+			continue
+		}
+
+		err = cfg.revokeAccessTokens(ctx, auth.RevokedSessionKey(session.FamilyID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revokeUserTokens logs userID out of every session, revoking their refresh
+// tokens and the access tokens already issued.
+func (cfg *ApiConfig) revokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	err := cfg.Db.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return cfg.revokeAccessTokens(ctx, auth.RevokedUserKey(userID))
+}
+
+// revokeOAuthGrants revokes every OAuth client's refresh tokens for userID
+// and the access tokens already issued from them.
+func (cfg *ApiConfig) revokeOAuthGrants(ctx context.Context, userID uuid.UUID) error {
+	err := cfg.Db.RevokeUserOAuthGrants(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return cfg.revokeAccessTokens(ctx, auth.RevokedUserClientsKey(userID))
+}
+
+// HashLegacyRefreshTokens hashes refresh tokens stored before tokens were
+// kept as keyed hashes, so that sessions from then carry on. Migrations can't
+// do it as they never see TOKEN_SECRET.
+func (cfg *ApiConfig) HashLegacyRefreshTokens(ctx context.Context) error {
+	var hashed int
+
+	err := cfg.inTx(ctx, func(q *database.Queries) error {
+		tokens, err := q.GetUnhashedRefreshTokens(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			hashParams := database.HashRefreshTokenParams{
+				TokenHash:   auth.HashToken(token.TokenHash, cfg.TokenSecret),
+				TokenPrefix: auth.TokenPrefix(token.TokenHash),
+				ReplacedBy:  token.ReplacedBy,
+				RawToken:    token.TokenHash,
+			}
+			if token.ReplacedBy.Valid {
+				hashParams.ReplacedBy.String = auth.HashToken(token.ReplacedBy.String, cfg.TokenSecret)
+			}
+
+			err = q.HashRefreshToken(ctx, hashParams)
+			if err != nil {
+				return err
+			}
+		}
+
+		hashed = len(tokens)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hashed > 0 {
+		log.Printf("Hashed %d refresh tokens stored in the clear", hashed)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type testSessionInfo struct {
+	ID      uuid.UUID `json:"id"`
+	Current bool      `json:"current"`
+}
+
+func newSessionsTestMux(cfg *ApiConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/sessions", http.HandlerFunc(cfg.SessionsHandler))
+	mux.Handle("DELETE /api/sessions/{sessionID}", http.HandlerFunc(cfg.DeleteSessionHandler))
+	mux.Handle("POST /api/sessions/revoke-others", http.HandlerFunc(cfg.RevokeOtherSessionsHandler))
+	mux.Handle("POST /api/refresh", http.HandlerFunc(cfg.RefreshHandler))
+	return mux
+}
+
+// listSessions returns the sessions the holder of token can see, and which of
+// them token belongs to.
+func listSessions(t *testing.T, mux http.Handler, token string) ([]testSessionInfo, uuid.UUID) {
+	t.Helper()
+
+	rec := doJSON(t, mux, "GET", "/api/sessions", token, nil)
+	if rec.Code != 200 {
+		t.Fatalf("Listing sessions: expected 200, got %d", rec.Code)
+	}
+
+	sessions := []testSessionInfo{}
+	decodeJSON(t, rec, &sessions)
+
+	current := uuid.Nil
+	for _, session := range sessions {
+		if session.Current {
+			current = session.ID
+		}
+	}
+	return sessions, current
+}
+
+func TestSessionsListAndDelete(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	mux := newSessionsTestMux(cfg)
+
+	laptop := registerAndLogin(t, cfg, "sessions@example.com", "sessions-password")
+	phone := loginTestUser(t, cfg, "sessions@example.com", "sessions-password")
+	tablet := loginTestUser(t, cfg, "sessions@example.com", "sessions-password")
+	stranger := registerAndLogin(t, cfg, "stranger@example.com", "stranger-password")
+
+	sessions, laptopSession := listSessions(t, mux, laptop.Token)
+	if len(sessions) != 3 || laptopSession == uuid.Nil {
+		t.Fatalf("Expected 3 sessions with the caller's marked current, got %+v", sessions)
+	}
+	_, phoneSession := listSessions(t, mux, phone.Token)
+
+	// Sessions of other users can't be seen or ended
+	strangerSessions, _ := listSessions(t, mux, stranger.Token)
+	if len(strangerSessions) != 1 {
+		t.Fatalf("Expected the other user to see only their session, got %+v", strangerSessions)
+	}
+
+	rec := doJSON(t, mux, "DELETE", "/api/sessions/"+phoneSession.String(), stranger.Token, nil)
+	if rec.Code != 404 {
+		t.Fatalf("Deleting another user's session: expected 404, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "DELETE", "/api/sessions/"+phoneSession.String(), laptop.Token, nil)
+	if rec.Code != 204 {
+		t.Fatalf("Deleting a session: expected 204, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "DELETE", "/api/sessions/"+phoneSession.String(), laptop.Token, nil)
+	if rec.Code != 404 {
+		t.Fatalf("Deleting a session twice: expected 404, got %d", rec.Code)
+	}
+
+	// The deleted session can neither refresh nor use its access token
+	rec = doJSON(t, mux, "POST", "/api/refresh", phone.RefreshToken, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Refresh from a deleted session: expected 401, got %d", rec.Code)
+	}
+	rec = doJSON(t, mux, "GET", "/api/sessions", phone.Token, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Access token from a deleted session: expected 401, got %d", rec.Code)
+	}
+
+	sessions, _ = listSessions(t, mux, laptop.Token)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions after deleting one, got %+v", sessions)
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/sessions/revoke-others", laptop.Token, nil)
+	if rec.Code != 204 {
+		t.Fatalf("Revoking other sessions: expected 204, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/refresh", tablet.RefreshToken, nil)
+	if rec.Code != 401 {
+		t.Fatalf("Refresh from a revoked session: expected 401, got %d", rec.Code)
+	}
+
+	sessions, current := listSessions(t, mux, laptop.Token)
+	if len(sessions) != 1 || current != laptopSession {
+		t.Fatalf("Expected only the caller's session to remain, got %+v", sessions)
+	}
+
+	rec = doJSON(t, mux, "POST", "/api/refresh", laptop.RefreshToken, nil)
+	if rec.Code != 200 {
+		t.Fatalf("Refresh from the remaining session: expected 200, got %d", rec.Code)
+	}
+}
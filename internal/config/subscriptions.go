@@ -0,0 +1,128 @@
+package config
+
+import (
+	"chirpy/internal/billing"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// chirpyRedBillingPeriod is how long a payment lasts when the provider does
+// not say.
+const chirpyRedBillingPeriod = 30 * 24 * time.Hour
+
+var errUnidentifiedRenewal = errors.New("renewal has neither an event ID nor a period end, so a retry could not be told apart")
+
+// applySubscriptionEvent moves a user's subscription through its lifecycle.
+// A canceled or past due subscription keeps Chirpy Red until the end of the
+// period that was paid for; ending it takes it away immediately.
+func (cfg *ApiConfig) applySubscriptionEvent(ctx context.Context, change billing.Change, event billing.Event) error {
+	var rows int64
+	var err error
+
+	switch change {
+	case billing.ChangeStart, billing.ChangeRenew:
+		start := time.Now().UTC()
+		if change == billing.ChangeRenew {
+			// A renewal carries on from the end of the current period, so
+			// applying one twice would give away a period. With a period end
+			// a repeat is spotted by the subscription already reaching it;
+			// without one only the provider's event ID can spot it.
+			if event.PeriodEnd == nil && !event.IDFromProvider {
+				return errUnidentifiedRenewal
+			}
+
+			current, err := cfg.Db.GetSubscription(ctx, event.UserID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if err == nil {
+				if event.PeriodEnd != nil && !event.PeriodEnd.After(current.CurrentPeriodEnd) {
+					log.Printf("Subscription of user %s already runs to %s", event.UserID, current.CurrentPeriodEnd)
+					return nil
+				}
+				if current.CurrentPeriodEnd.After(start) {
+					start = current.CurrentPeriodEnd
+				}
+			}
+		}
+		if event.PeriodStart != nil {
+			start = event.PeriodStart.UTC()
+		}
+
+		end := start.Add(chirpyRedBillingPeriod)
+		if event.PeriodEnd != nil {
+			end = event.PeriodEnd.UTC()
+		}
+		if !end.After(start) {
+			return fmt.Errorf("subscription period ends before it starts: %s to %s", start, end)
+		}
+
+		startParams := database.StartSubscriptionParams{
+			UserID:             event.UserID,
+			CurrentPeriodStart: start,
+			CurrentPeriodEnd:   end,
+		}
+
+		_, err = cfg.Db.StartSubscription(ctx, startParams)
+		return err
+	case billing.ChangePastDue:
+		rows, err = cfg.Db.MarkSubscriptionPastDue(ctx, event.UserID)
+	case billing.ChangeCancel:
+		rows, err = cfg.Db.CancelSubscription(ctx, event.UserID)
+	case billing.ChangeEnd:
+		rows, err = cfg.Db.EndSubscription(ctx, event.UserID)
+	default:
+		return fmt.Errorf("unknown subscription change %q", change)
+	}
+
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("no subscription to update for user %s", event.UserID)
+	}
+	return nil
+}
+
+// isChirpyRed reports whether the user's subscription currently covers them.
+// Errors count as not subscribed.
+func (cfg *ApiConfig) isChirpyRed(ctx context.Context, userID uuid.UUID) bool {
+	red, err := cfg.Db.UserHasChirpyRed(ctx, userID)
+	if err != nil {
+		log.Printf("Error checking Chirpy Red subscription: %s", err)
+		return false
+	}
+	return red
+}
+
+// SweepSubscriptionsEvery marks subscriptions whose period has run out as
+// expired. Chirpy Red is already withheld from them as soon as the period
+// ends; the sweep keeps the stored status in step.
+func (cfg *ApiConfig) SweepSubscriptionsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rows, err := cfg.Db.ExpireSubscriptions(ctx)
+		if err != nil {
+			log.Printf("Error expiring subscriptions: %s", err)
+			continue
+		}
+		if rows > 0 {
+			log.Printf("Expired %d Chirpy Red subscriptions", rows)
+		}
+	}
+}
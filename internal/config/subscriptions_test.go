@@ -0,0 +1,139 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	cfg, _, db := newTestConfig(t)
+	mux := newWebhookTestMux(cfg)
+	user := registerAndLogin(t, cfg, "lifecycle@example.com", "lifecycle-password")
+	now := time.Now()
+
+	send := func(id, eventType string, periodEnd *time.Time) {
+		t.Helper()
+		rec := sendWebhook(t, mux, subscriptionEvent(id, eventType, user.UserID, periodEnd), now)
+		if rec.Code != 204 {
+			t.Fatalf("%s: expected 204, got %d", eventType, rec.Code)
+		}
+	}
+
+	expect := func(status string, red bool) time.Time {
+		t.Helper()
+		subscription, err := cfg.Db.GetSubscription(context.Background(), user.UserID)
+		if err != nil {
+			t.Fatalf("Error getting subscription: %s", err)
+		}
+		gotRed := cfg.isChirpyRed(context.Background(), user.UserID)
+		if subscription.Status != status || gotRed != red {
+			t.Fatalf("Expected status %s with Chirpy Red %t, got %s with %t", status, red, subscription.Status, gotRed)
+		}
+		return subscription.CurrentPeriodEnd
+	}
+
+	periodEnd := now.Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	send("evt_started", "subscription.started", &periodEnd)
+	if end := expect("active", true); !end.Equal(periodEnd) {
+		t.Fatalf("Expected the period to end at %s, got %s", periodEnd, end)
+	}
+
+	// A renewal without a period end carries on from the current one
+	send("evt_renewed", "subscription.renewed", nil)
+	if end := expect("active", true); !end.Equal(periodEnd.Add(chirpyRedBillingPeriod)) {
+		t.Fatalf("Expected the renewal to add a period, got an end of %s", end)
+	}
+
+	// Past due and canceled subscriptions keep what was paid for
+	send("evt_past_due", "subscription.past_due", nil)
+	expect("past_due", true)
+
+	send("evt_canceled", "subscription.canceled", nil)
+	expect("canceled", true)
+
+	send("evt_ended", "subscription.ended", nil)
+	expect("expired", false)
+
+	// A subscription whose period runs out is withheld at once, and the
+	// sweep then marks it expired
+	send("evt_restarted", "subscription.started", &periodEnd)
+	expect("active", true)
+
+	_, err := db.Exec("UPDATE subscriptions SET current_period_end = NOW() - INTERVAL '1 minute' WHERE user_id = $1", user.UserID)
+	if err != nil {
+		t.Fatalf("Error backdating subscription: %s", err)
+	}
+	expect("active", false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cfg.SweepSubscriptionsEvery(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		subscription, err := cfg.Db.GetSubscription(context.Background(), user.UserID)
+		if err != nil {
+			t.Fatalf("Error getting subscription: %s", err)
+		}
+		if subscription.Status == "expired" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the sweep to expire the subscription, still %s", subscription.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Only subscribers can be moved through the lifecycle
+	rec := sendWebhook(t, mux, subscriptionEvent("evt_stranger", "subscription.canceled", uuid.New(), nil), now)
+	if rec.Code != 404 {
+		t.Fatalf("Cancel without a subscription: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestSubscriptionTransitions(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	mux := newWebhookTestMux(cfg)
+	user := registerAndLogin(t, cfg, "transitions@example.com", "transitions-password")
+	now := time.Now()
+
+	cases := []struct {
+		id        string
+		eventType string
+		wantCode  int
+		status    string
+	}{
+		{"evt_1", "subscription.started", 204, "active"},
+		{"evt_2", "subscription.canceled", 204, "canceled"},
+		// A canceled subscription can't fall behind on payments it no
+		// longer takes
+		{"evt_3", "subscription.past_due", 404, "canceled"},
+		// Renewing undoes the cancellation
+		{"evt_4", "subscription.renewed", 204, "active"},
+		{"evt_5", "subscription.past_due", 204, "past_due"},
+		{"evt_6", "subscription.past_due", 204, "past_due"},
+		{"evt_7", "subscription.ended", 204, "expired"},
+		// Once ended, only starting again changes anything
+		{"evt_8", "subscription.canceled", 404, "expired"},
+		{"evt_9", "subscription.past_due", 404, "expired"},
+		{"evt_10", "subscription.started", 204, "active"},
+	}
+
+	for _, c := range cases {
+		rec := sendWebhook(t, mux, subscriptionEvent(c.id, c.eventType, user.UserID, nil), now)
+		if rec.Code != c.wantCode {
+			t.Fatalf("%s %s: expected %d, got %d", c.id, c.eventType, c.wantCode, rec.Code)
+		}
+
+		subscription, err := cfg.Db.GetSubscription(context.Background(), user.UserID)
+		if err != nil {
+			t.Fatalf("Error getting subscription: %s", err)
+		}
+		if subscription.Status != c.status {
+			t.Fatalf("After %s %s: expected status %s, got %s", c.id, c.eventType, c.status, subscription.Status)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errInsufficientScope = errors.New("token lacks required scope")
+
+// principal is whoever a request is authenticated as, and what they may do.
+type principal struct {
+	UserID    uuid.UUID
+	SessionID string
+	ClientID  string
+	Role      string
+	// Scopes is nil for a user's own session token, which may do anything.
+	Scopes []string
+}
+
+func (p principal) can(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	return scope != "" && auth.HasScope(p.Scopes, scope)
+}
+
+// authenticate accepts an access token, an OAuth client's access token or a
+// personal access token in the Authorization header, or an access token in
+// the session cookie. Pass an empty scope for endpoints that only the user
+// themselves, never a scoped token, may call.
+func (cfg *ApiConfig) authenticate(r *http.Request, scope string) (principal, error) {
+	token, fromCookie, err := requestToken(r, accessTokenCookie)
+	if err != nil {
+		return principal{}, err
+	}
+
+	caller := principal{}
+
+	if auth.IsPersonalAccessToken(token) && !fromCookie {
+		pat, err := cfg.Db.GetPersonalAccessToken(r.Context(), auth.HashToken(token, cfg.TokenSecret))
+		if err != nil {
+			return principal{}, fmt.Errorf("unknown personal access token: %w", err)
+		}
+
+		err = cfg.Db.TouchPersonalAccessToken(r.Context(), pat.ID)
+		if err != nil {
+			log.Printf("Error updating personal access token last use: %s", err)
+		}
+
+		// Scoped tokens never carry elevated privileges
+		caller.UserID = pat.UserID
+		caller.Role = auth.RoleUser
+		caller.Scopes = auth.ParseScopes(pat.Scopes)
+	} else {
+		claims, err := auth.ParseJWT(token, cfg.Keys, auth.AccessTokenAudience)
+		if err != nil {
+			return principal{}, err
+		}
+
+		caller.UserID, err = uuid.Parse(claims.Subject)
+		if err != nil {
+			return principal{}, err
+		}
+		if fromCookie {
+			err = cfg.checkCSRF(r, claims.SessionID)
+			if err != nil {
+				return principal{}, err
+			}
+		}
+
+		caller.SessionID = claims.SessionID
+		caller.Role = claims.Role
+		if caller.Role == "" {
+			caller.Role = auth.RoleUser
+		}
+
+		// Tokens issued to OAuth clients are limited to what the user granted
+		if claims.ClientID != "" {
+			caller.ClientID = claims.ClientID
+			caller.Role = auth.RoleUser
+			caller.Scopes = auth.ParseScopes(claims.Scope)
+		}
+	}
+
+	if !caller.can(scope) {
+		return principal{}, errInsufficientScope
+	}
+
+	return caller, nil
+}
+
+func authErrorStatus(err error) int {
+	if errors.Is(err, errInsufficientScope) || errors.Is(err, errInvalidCSRFToken) {
+		return 403
+	}
+	return 401
+}
+
+type personalAccessTokenResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func newPersonalAccessTokenResponse(pat database.PersonalAccessToken) personalAccessTokenResponse {
+	resp := personalAccessTokenResponse{
+		ID:        pat.ID,
+		Name:      pat.Name,
+		Prefix:    auth.PersonalAccessTokenPrefix + pat.TokenPrefix,
+		Scopes:    auth.ParseScopes(pat.Scopes),
+		CreatedAt: pat.CreatedAt,
+	}
+
+	if pat.LastUsedAt.Valid {
+		resp.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	if pat.ExpiresAt.Valid {
+		resp.ExpiresAt = &pat.ExpiresAt.Time
+	}
+
+	return resp
+}
+
+func (cfg *ApiConfig) CreatePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	scopes, err := auth.ValidateScopes(params.Scopes)
+	if err != nil || len(scopes) == 0 || params.Name == "" {
+		log.Printf("Invalid personal access token request: %v", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	rawToken, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		log.Printf("Error generating personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	createParams := database.CreatePersonalAccessTokenParams{
+		UserID:      caller.UserID,
+		Name:        params.Name,
+		TokenHash:   auth.HashToken(rawToken, cfg.TokenSecret),
+		TokenPrefix: auth.TokenPrefix(rawToken[len(auth.PersonalAccessTokenPrefix):]),
+		Scopes:      auth.FormatScopes(scopes),
+	}
+	if params.ExpiresInDays > 0 {
+		createParams.ExpiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	pat, err := cfg.Db.CreatePersonalAccessToken(r.Context(), createParams)
+	if err != nil {
+		log.Printf("Error creating personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	respStruct := newPersonalAccessTokenResponse(pat)
+	respStruct.Token = rawToken
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) PersonalAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	pats, err := cfg.Db.GetUserPersonalAccessTokens(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("Error retrieving personal access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	respStruct := []personalAccessTokenResponse{}
+	for _, pat := range pats {
+		respStruct = append(respStruct, newPersonalAccessTokenResponse(pat))
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+func (cfg *ApiConfig) RevokePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, "")
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		log.Printf("Invalid token ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	revokeParams := database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: caller.UserID,
+	}
+
+	rows, err := cfg.Db.RevokePersonalAccessToken(r.Context(), revokeParams)
+	if err != nil {
+		log.Printf("Error revoking personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditTokenRevoke, Outcome: auditSuccess, ActorID: caller.UserID, SubjectID: caller.UserID, Details: "personal access token " + tokenID.String()})
+
+	w.WriteHeader(204)
+}
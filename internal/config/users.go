@@ -1,9 +1,9 @@
 package config
 
 import (
-	"chirpy/internal/auth"
 	"chirpy/internal/database"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -27,14 +27,15 @@ func (cfg *ApiConfig) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type returnVals struct {
-		ID          uuid.UUID `json:"id"`
-		Email       string    `json:"email"`
-		Created_at  time.Time `json:"created_at"`
-		Updated_at  time.Time `json:"updated_at"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
+		ID            uuid.UUID `json:"id"`
+		Email         string    `json:"email"`
+		Created_at    time.Time `json:"created_at"`
+		Updated_at    time.Time `json:"updated_at"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+		EmailVerified bool      `json:"email_verified"`
 	}
 
-	hashedPass, err := auth.HashPassword(params.Password)
+	hashedPass, err := cfg.Passwords.Hash(params.Password)
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err)
 		w.WriteHeader(500)
@@ -53,12 +54,17 @@ func (cfg *ApiConfig) UsersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = cfg.sendVerificationEmail(r.Context(), respBody)
+	if err != nil {
+		log.Printf("Error sending verification email: %s", err)
+	}
+
 	respStruct := returnVals{
-		ID:          respBody.ID,
-		Email:       respBody.Email,
-		Created_at:  respBody.CreatedAt,
-		Updated_at:  respBody.UpdatedAt,
-		IsChirpyRed: respBody.IsChirpyRed,
+		ID:            respBody.ID,
+		Email:         respBody.Email,
+		Created_at:    respBody.CreatedAt,
+		Updated_at:    respBody.UpdatedAt,
+		EmailVerified: respBody.EmailVerifiedAt.Valid,
 	}
 
 	dat, err := json.Marshal(respStruct)
@@ -73,10 +79,14 @@ func (cfg *ApiConfig) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(dat)
 }
 
+// UsersPutHandler changes the caller's email address and password. It takes
+// the user's own session and their current password, never a scoped token,
+// so that a leaked token or a third-party client can't take the account over.
 func (cfg *ApiConfig) UsersPutHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -88,54 +98,115 @@ func (cfg *ApiConfig) UsersPutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.GetToken(r.Header, "Bearer ")
+	caller, err := cfg.authenticate(r, "")
 	if err != nil {
-		log.Printf("Error getting access token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
 		return
 	}
 
-	user, err := auth.ValidateJWT(token, cfg.TokenSecret)
+	type returnVals struct {
+		ID            uuid.UUID `json:"id"`
+		Email         string    `json:"email"`
+		Created_at    time.Time `json:"created_at"`
+		Updated_at    time.Time `json:"updated_at"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+		EmailVerified bool      `json:"email_verified"`
+	}
+
+	current, err := cfg.Db.GetUserByID(r.Context(), caller.UserID)
 	if err != nil {
-		log.Printf("Error validating access token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
 		return
 	}
 
-	type returnVals struct {
-		ID          uuid.UUID `json:"id"`
-		Email       string    `json:"email"`
-		Created_at  time.Time `json:"created_at"`
-		Updated_at  time.Time `json:"updated_at"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
+	_, remaining, err := cfg.checkPassword(r.Context(), current.Email, params.CurrentPassword, cfg.clientIP(r))
+	if errors.Is(err, errLockedOut) {
+		log.Printf("Profile update while locked out")
+		respondLockedOut(w, remaining)
+		return
+	}
+	if errors.Is(err, errBadCredentials) {
+		log.Printf("Wrong current password: %s", err)
+		cfg.audit(r, auditEntry{Event: auditUserUpdate, Outcome: auditFailure, ActorID: caller.UserID, SubjectID: caller.UserID, Details: "wrong current password"})
+		w.WriteHeader(401)
+		return
 	}
-
-	hashedPass, err := auth.HashPassword(params.Password)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		log.Printf("Error checking password: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	// Either field can be left out to keep its current value
 	updateUserParams := database.UpdateUserParams{
-		Email:          params.Email,
-		HashedPassword: hashedPass,
-		ID:             user,
+		Email:          current.Email,
+		HashedPassword: current.HashedPassword,
+		ID:             caller.UserID,
+	}
+	if params.Email != "" {
+		updateUserParams.Email = params.Email
+	}
+
+	passwordChanged := params.Password != ""
+	if passwordChanged {
+		updateUserParams.HashedPassword, err = cfg.Passwords.Hash(params.Password)
+		if err != nil {
+			log.Printf("Error hashing password: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		// A new password logs out every other session and every OAuth
+		// client; the caller's own session stays. This happens first so
+		// that a failure here never leaves the password changed behind the
+		// client's back.
+		sessionID, _ := uuid.Parse(caller.SessionID)
+
+		err = cfg.revokeOtherSessions(r.Context(), caller.UserID, sessionID)
+		if err != nil {
+			log.Printf("Error revoking sessions before password change: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		err = cfg.revokeOAuthGrants(r.Context(), caller.UserID)
+		if err != nil {
+			log.Printf("Error revoking OAuth grants before password change: %s", err)
+			w.WriteHeader(500)
+			return
+		}
 	}
 
 	respBody, err := cfg.Db.UpdateUser(r.Context(), updateUserParams)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		log.Printf("Error updating user: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	if current.Email != respBody.Email {
+		cfg.audit(r, auditEntry{Event: auditUserUpdate, Outcome: auditSuccess, ActorID: caller.UserID, SubjectID: caller.UserID, Details: "email changed from " + current.Email})
+	}
+	if passwordChanged {
+		cfg.audit(r, auditEntry{Event: auditPasswordChange, Outcome: auditSuccess, ActorID: caller.UserID, SubjectID: caller.UserID})
+	}
+
+	if !respBody.EmailVerifiedAt.Valid {
+		err = cfg.sendVerificationEmail(r.Context(), respBody)
+		if err != nil {
+			log.Printf("Error sending verification email: %s", err)
+		}
+	}
+
 	respStruct := returnVals{
-		ID:          respBody.ID,
-		Email:       respBody.Email,
-		Created_at:  respBody.CreatedAt,
-		Updated_at:  respBody.UpdatedAt,
-		IsChirpyRed: respBody.IsChirpyRed,
+		ID:            respBody.ID,
+		Email:         respBody.Email,
+		Created_at:    respBody.CreatedAt,
+		Updated_at:    respBody.UpdatedAt,
+		IsChirpyRed:   cfg.isChirpyRed(r.Context(), respBody.ID),
+		EmailVerified: respBody.EmailVerifiedAt.Valid,
 	}
 
 	dat, err := json.Marshal(respStruct)
@@ -150,52 +221,8 @@ func (cfg *ApiConfig) UsersPutHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(dat)
 }
 
+// ChirpyRedHandler is the webhook URL Polka was first set up with.
 func (cfg *ApiConfig) ChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
-
-	token, err := auth.GetToken(r.Header, "ApiKey ")
-	if err != nil {
-		log.Printf("error parsing API Key: %s", err)
-		w.WriteHeader(401)
-		return
-	}
-
-	if token != cfg.PolkaKey {
-		log.Printf("Invalid API Key: %s", err)
-		w.WriteHeader(401)
-		return
-	}
-
-	type data struct {
-		UserId uuid.UUID `json:"user_id"`
-	}
-
-	type parameters struct {
-		Event string `json:"event"`
-		Data  data   `json:"data"`
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
-	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		w.WriteHeader(500)
-		return
-	}
-
-	if params.Event != "user.upgraded" {
-		log.Printf("Invalid polka event: %s", err)
-		w.WriteHeader(204)
-		return
-	}
-
-	err = cfg.Db.UpgradeChirpyRed(r.Context(), params.Data.UserId)
-	if err != nil {
-		log.Printf("Error upgrading user: %s", err)
-		w.WriteHeader(404)
-		return
-	}
-
-	w.WriteHeader(204)
-
+	r.SetPathValue("provider", "polka")
+	cfg.WebhookHandler(w, r)
 }
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"net/http"
+	"testing"
+)
+
+func TestUpdateUserTakesSessionAndCurrentPassword(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	session := registerAndLogin(t, cfg, "update@example.com", "update-password")
+
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/users", http.HandlerFunc(cfg.UsersPutHandler))
+	mux.Handle("POST /api/tokens", http.HandlerFunc(cfg.CreatePersonalAccessTokenHandler))
+
+	rec := doJSON(t, mux, "POST", "/api/tokens", session.Token, map[string]any{
+		"name":   "profile",
+		"scopes": []string{auth.ScopeProfileWrite},
+	})
+	if rec.Code != 201 {
+		t.Fatalf("Creating token: expected 201, got %d", rec.Code)
+	}
+
+	pat := personalAccessTokenResponse{}
+	decodeJSON(t, rec, &pat)
+
+	takeover := map[string]string{
+		"email":            "attacker@example.com",
+		"password":         "attacker-password",
+		"current_password": "update-password",
+	}
+
+	rec = doJSON(t, mux, "PUT", "/api/users", pat.Token, takeover)
+	if rec.Code != 403 {
+		t.Fatalf("Update with a personal access token: expected 403, got %d", rec.Code)
+	}
+
+	takeover["current_password"] = "wrong-password"
+	rec = doJSON(t, mux, "PUT", "/api/users", session.Token, takeover)
+	if rec.Code != 401 {
+		t.Fatalf("Update with the wrong current password: expected 401, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "PUT", "/api/users", session.Token, map[string]string{
+		"email":            "updated@example.com",
+		"password":         "new-password",
+		"current_password": "update-password",
+	})
+	if rec.Code != 200 {
+		t.Fatalf("Update with the current password: expected 200, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, http.HandlerFunc(cfg.LoginHandler), "POST", "/api/login", "", map[string]string{"email": "updated@example.com", "password": "new-password"})
+	if rec.Code != 200 {
+		t.Fatalf("Login with the new credentials: expected 200, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "PUT", "/api/users", session.Token, map[string]string{
+		"email":            "renamed@example.com",
+		"current_password": "new-password",
+	})
+	if rec.Code != 200 {
+		t.Fatalf("Email-only update: expected 200, got %d", rec.Code)
+	}
+
+	loginTestUser(t, cfg, "renamed@example.com", "new-password")
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/mail"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const verificationTokenLifetime = 24 * time.Hour
+
+func (cfg *ApiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	payload := auth.SignedToken{
+		ID:        uuid.NewString(),
+		Purpose:   auth.PurposeVerifyEmail,
+		Subject:   user.ID.String(),
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(verificationTokenLifetime).Unix(),
+	}
+
+	token, err := auth.MakeSignedToken(payload, cfg.TokenSecret)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.BaseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+
+	return cfg.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body:    fmt.Sprintf("Confirm this is your email address by opening the link below. It expires in 24 hours.\n\n%s\n", link),
+	})
+}
+
+// consumeSignedToken records token as spent, returning false if it already was.
+func (cfg *ApiConfig) consumeSignedToken(ctx context.Context, token auth.SignedToken) (bool, error) {
+	consumeParams := database.ConsumeTokenParams{
+		ID:        token.ID,
+		ExpiresAt: time.Unix(token.ExpiresAt, 0).UTC(),
+	}
+
+	rows, err := cfg.Db.ConsumeToken(ctx, consumeParams)
+	if err != nil {
+		return false, err
+	}
+
+	err = cfg.Db.DeleteExpiredConsumedTokens(ctx)
+	if err != nil {
+		log.Printf("Error deleting expired consumed tokens: %s", err)
+	}
+
+	return rows == 1, nil
+}
+
+func (cfg *ApiConfig) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.ParseSignedToken(r.URL.Query().Get("token"), cfg.TokenSecret, auth.PurposeVerifyEmail)
+	if err != nil {
+		log.Printf("Invalid verification token: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	userID, err := uuid.Parse(token.Subject)
+	if err != nil {
+		log.Printf("Invalid verification token subject: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	fresh, err := cfg.consumeSignedToken(r.Context(), token)
+	if err != nil {
+		log.Printf("Error consuming verification token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !fresh {
+		log.Printf("Verification token already used")
+		w.WriteHeader(400)
+		return
+	}
+
+	verifyParams := database.VerifyEmailParams{
+		ID:    userID,
+		Email: token.Email,
+	}
+
+	rows, err := cfg.Db.VerifyEmail(r.Context(), verifyParams)
+	if err != nil {
+		log.Printf("Error verifying email: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if rows == 0 {
+		log.Printf("Email changed since verification token was issued")
+		w.WriteHeader(400)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	w.Write([]byte("Email verified"))
+}
+
+func (cfg *ApiConfig) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authenticate(r, auth.ScopeProfileWrite)
+	if err != nil {
+		log.Printf("Error authenticating request: %s", err)
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	user, err := cfg.Db.GetUserByID(r.Context(), caller.UserID)
+	if err != nil {
+		log.Printf("User not found: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	if user.EmailVerifiedAt.Valid {
+		w.WriteHeader(204)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		log.Printf("Error sending verification email: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(202)
+}
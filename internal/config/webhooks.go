@@ -0,0 +1,287 @@
+package config
+
+import (
+	"chirpy/internal/billing"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxWebhookBodyBytes = 1 << 20
+
+const (
+	webhookProcessed = "processed"
+	webhookIgnored   = "ignored"
+	webhookFailed    = "failed"
+
+	defaultWebhookPageSize = 50
+	maxWebhookPageSize     = 500
+)
+
+// WebhookHandler receives subscription webhooks from the payment provider
+// named in the path. Every webhook is stored before it is acted on so a
+// redelivery is recognised and nothing is lost if processing fails part way
+// through.
+func (cfg *ApiConfig) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	provider, ok := cfg.PaymentProviders[providerName]
+	if !ok {
+		log.Printf("Unknown payment provider: %q", providerName)
+		w.WriteHeader(404)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		log.Printf("Error reading webhook body: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	err = provider.VerifyRequest(r, body)
+	if err != nil {
+		log.Printf("Error authenticating %s webhook: %s", providerName, err)
+		cfg.audit(r, auditEntry{Event: auditChirpyRed, Outcome: auditDenied, Details: providerName + ": " + err.Error()})
+		w.WriteHeader(401)
+		return
+	}
+
+	// A body that can't be read never will be, so tell the provider to stop
+	parsed, err := provider.ParseEvent(r.Header, body)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	recordParams := database.RecordWebhookEventParams{
+		Provider: providerName,
+		EventID:  parsed.ID,
+		Event:    parsed.Type,
+		Payload:  string(body),
+	}
+
+	stored, err := cfg.Db.RecordWebhookEvent(r.Context(), recordParams)
+	if err != nil {
+		log.Printf("Error storing webhook event: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	event, err := cfg.Db.ClaimWebhookEvent(r.Context(), stored.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Ignoring duplicate %s event %s (%s)", providerName, stored.EventID, stored.Status)
+		w.WriteHeader(204)
+		return
+	}
+	if err != nil {
+		log.Printf("Error claiming webhook event: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	status, err := cfg.processWebhookEvent(r.Context(), event)
+	if status == webhookIgnored {
+		log.Printf("Ignoring %s event: %s", providerName, parsed.Type)
+		w.WriteHeader(204)
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying %s event %s: %s", providerName, parsed.Type, err)
+		cfg.audit(r, auditEntry{Event: auditChirpyRed, Outcome: auditFailure, SubjectID: parsed.UserID, Details: providerName + " " + parsed.Type + ": " + err.Error()})
+		if errors.Is(err, errUnidentifiedRenewal) {
+			w.WriteHeader(400)
+			return
+		}
+		w.WriteHeader(404)
+		return
+	}
+
+	cfg.audit(r, auditEntry{Event: auditChirpyRed, Outcome: auditSuccess, SubjectID: parsed.UserID, Details: providerName + " " + parsed.Type})
+
+	w.WriteHeader(204)
+}
+
+// processWebhookEvent applies an event claimed from the inbox and records how
+// it went. It returns the event's new status.
+func (cfg *ApiConfig) processWebhookEvent(ctx context.Context, event database.WebhookEvent) (string, error) {
+	status := webhookProcessed
+
+	provider, ok := cfg.PaymentProviders[event.Provider]
+	if !ok {
+		err := fmt.Errorf("payment provider %q is not configured", event.Provider)
+		return webhookFailed, cfg.markWebhookEventFailed(ctx, event, err)
+	}
+
+	parsed, err := provider.ParseEvent(nil, []byte(event.Payload))
+	if err == nil {
+		change := provider.EntitlementChange(parsed)
+		if change == billing.ChangeNone {
+			status = webhookIgnored
+		} else {
+			err = cfg.applySubscriptionEvent(ctx, change, parsed)
+		}
+	}
+
+	if err != nil {
+		return webhookFailed, cfg.markWebhookEventFailed(ctx, event, err)
+	}
+
+	doneParams := database.MarkWebhookEventDoneParams{
+		ID:     event.ID,
+		Status: status,
+	}
+
+	err = cfg.Db.MarkWebhookEventDone(ctx, doneParams)
+	if err != nil {
+		log.Printf("Error marking webhook event %s done: %s", event.ID, err)
+	}
+	return status, nil
+}
+
+// markWebhookEventFailed records why event failed and returns that reason.
+func (cfg *ApiConfig) markWebhookEventFailed(ctx context.Context, event database.WebhookEvent, reason error) error {
+	failedParams := database.MarkWebhookEventFailedParams{
+		ID:        event.ID,
+		LastError: reason.Error(),
+	}
+
+	err := cfg.Db.MarkWebhookEventFailed(ctx, failedParams)
+	if err != nil {
+		log.Printf("Error marking webhook event %s failed: %s", event.ID, err)
+	}
+	return reason
+}
+
+// WebhookEventsHandler lists received webhooks newest first, optionally only
+// those with the given status (for example ?status=failed).
+func (cfg *ApiConfig) WebhookEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, offset, err := parsePage(query, defaultWebhookPageSize, maxWebhookPageSize)
+	if err != nil {
+		log.Printf("Invalid page: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	eventsParams := database.GetWebhookEventsParams{
+		Status:    sql.NullString{String: query.Get("status"), Valid: query.Get("status") != ""},
+		RowLimit:  limit,
+		RowOffset: offset,
+	}
+
+	events, err := cfg.Db.GetWebhookEvents(r.Context(), eventsParams)
+	if err != nil {
+		log.Printf("Error getting webhook events: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	respStruct := make([]webhookEventResponse, 0, len(events))
+	for _, event := range events {
+		respStruct = append(respStruct, newWebhookEventResponse(event))
+	}
+
+	dat, err := json.Marshal(respStruct)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+// ReplayWebhookEventHandler processes a stored webhook again. Only events
+// that failed, or that were left mid-processing by a crash, can be replayed.
+func (cfg *ApiConfig) ReplayWebhookEventHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		log.Printf("Invalid webhook event ID: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	event, err := cfg.Db.ClaimWebhookEvent(r.Context(), eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = cfg.Db.GetWebhookEvent(r.Context(), eventID)
+		if err != nil {
+			log.Printf("Webhook event not found: %s", err)
+			w.WriteHeader(404)
+			return
+		}
+
+		log.Printf("Webhook event %s is not waiting to be replayed", eventID)
+		w.WriteHeader(409)
+		return
+	}
+	if err != nil {
+		log.Printf("Error claiming webhook event: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	event.Status, err = cfg.processWebhookEvent(r.Context(), event)
+	if err != nil {
+		log.Printf("Error replaying webhook event %s: %s", event.ID, err)
+		event.LastError = err.Error()
+		cfg.audit(r, auditEntry{Event: auditReplayWebhook, Outcome: auditFailure, ActorID: adminActor(r), Details: event.ID.String() + ": " + err.Error()})
+	} else {
+		event.LastError = ""
+		cfg.audit(r, auditEntry{Event: auditReplayWebhook, Outcome: auditSuccess, ActorID: adminActor(r), Details: event.ID.String()})
+	}
+
+	dat, err := json.Marshal(newWebhookEventResponse(event))
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(dat)
+}
+
+type webhookEventResponse struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+}
+
+func newWebhookEventResponse(event database.WebhookEvent) webhookEventResponse {
+	resp := webhookEventResponse{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		Provider:  event.Provider,
+		EventID:   event.EventID,
+		Event:     event.Event,
+		Payload:   json.RawMessage(event.Payload),
+		Status:    event.Status,
+		Attempts:  event.Attempts,
+		LastError: event.LastError,
+	}
+	if event.ProcessedAt.Valid {
+		resp.ProcessedAt = &event.ProcessedAt.Time
+	}
+	return resp
+}
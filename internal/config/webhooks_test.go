@@ -0,0 +1,222 @@
+package config
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/billing"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+func newWebhookTestMux(cfg *ApiConfig) *http.ServeMux {
+	cfg.PaymentProviders["generic"] = billing.Generic{Secrets: []string{testWebhookSecret}}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/webhooks/{provider}", http.HandlerFunc(cfg.WebhookHandler))
+	return mux
+}
+
+// sendWebhook delivers body as a Chirpy format webhook signed at timestamp,
+// as a provider retrying a delivery would sign it afresh.
+func sendWebhook(t *testing.T, mux http.Handler, body string, timestamp time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/api/webhooks/generic", strings.NewReader(body))
+	req.Header.Set(billing.GenericTimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(billing.GenericSignatureHeader, auth.SignWebhook([]byte(body), timestamp.Unix(), testWebhookSecret))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func subscriptionEvent(id, eventType string, userID uuid.UUID, periodEnd *time.Time) string {
+	fields := []string{fmt.Sprintf(`"type":%q`, eventType), fmt.Sprintf(`"user_id":%q`, userID)}
+	if id != "" {
+		fields = append(fields, fmt.Sprintf(`"id":%q`, id))
+	}
+	if periodEnd != nil {
+		fields = append(fields, fmt.Sprintf(`"period_end":%q`, periodEnd.Format(time.RFC3339)))
+	}
+	return "{" + strings.Join(fields, ",") + "}"
+}
+
+func TestRenewalRetriesAreIdempotent(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	mux := newWebhookTestMux(cfg)
+	user := registerAndLogin(t, cfg, "renew@example.com", "renew-password")
+	now := time.Now()
+
+	rec := sendWebhook(t, mux, subscriptionEvent("evt_start", "subscription.started", user.UserID, nil), now)
+	if rec.Code != 204 {
+		t.Fatalf("Start: expected 204, got %d", rec.Code)
+	}
+
+	periodEnd := now.Add(60 * 24 * time.Hour).UTC().Truncate(time.Second)
+	renewal := subscriptionEvent("", "subscription.renewed", user.UserID, &periodEnd)
+
+	for i := range 2 {
+		rec = sendWebhook(t, mux, renewal, now.Add(time.Duration(i)*time.Second))
+		if rec.Code != 204 {
+			t.Fatalf("Renewal delivery %d: expected 204, got %d", i+1, rec.Code)
+		}
+	}
+
+	subscription, err := cfg.Db.GetSubscription(context.Background(), user.UserID)
+	if err != nil {
+		t.Fatalf("Error getting subscription: %s", err)
+	}
+	if !subscription.CurrentPeriodEnd.Equal(periodEnd) {
+		t.Fatalf("Expected the period to end at %s, got %s", periodEnd, subscription.CurrentPeriodEnd)
+	}
+
+	rec = sendWebhook(t, mux, subscriptionEvent("", "subscription.renewed", user.UserID, nil), now)
+	if rec.Code != 400 {
+		t.Fatalf("Renewal without an ID or period end: expected 400, got %d", rec.Code)
+	}
+
+	rec = sendWebhook(t, mux, `{"type":`, now)
+	if rec.Code != 400 {
+		t.Fatalf("Malformed body: expected 400, got %d", rec.Code)
+	}
+}
+
+type testWebhookEvent struct {
+	ID       uuid.UUID `json:"id"`
+	EventID  string    `json:"event_id"`
+	Status   string    `json:"status"`
+	Attempts int32     `json:"attempts"`
+}
+
+func TestWebhookDedupAndReplay(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	mux := newWebhookTestMux(cfg)
+	mux.Handle("GET /admin/webhooks", http.HandlerFunc(cfg.WebhookEventsHandler))
+	mux.Handle("POST /admin/webhooks/{eventID}/replay", http.HandlerFunc(cfg.ReplayWebhookEventHandler))
+
+	subscriber := registerAndLogin(t, cfg, "dedup@example.com", "dedup-password")
+	latecomer := registerAndLogin(t, cfg, "replay@example.com", "replay-password")
+	now := time.Now()
+
+	periodEnd := func() time.Time {
+		t.Helper()
+		subscription, err := cfg.Db.GetSubscription(context.Background(), subscriber.UserID)
+		if err != nil {
+			t.Fatalf("Error getting subscription: %s", err)
+		}
+		return subscription.CurrentPeriodEnd
+	}
+
+	webhookEvents := func(status string) map[string]testWebhookEvent {
+		t.Helper()
+		rec := doJSON(t, mux, "GET", "/admin/webhooks?status="+status, "", nil)
+		if rec.Code != 200 {
+			t.Fatalf("Listing webhook events: expected 200, got %d", rec.Code)
+		}
+		events := []testWebhookEvent{}
+		decodeJSON(t, rec, &events)
+
+		byEventID := map[string]testWebhookEvent{}
+		for _, event := range events {
+			byEventID[event.EventID] = event
+		}
+		return byEventID
+	}
+
+	// Every delivery of an event is signed afresh, but the event ID ties
+	// them together so a renewal only ever adds one period
+	for i := range 2 {
+		rec := sendWebhook(t, mux, subscriptionEvent("evt_started", "subscription.started", subscriber.UserID, nil), now.Add(time.Duration(i)*time.Second))
+		if rec.Code != 204 {
+			t.Fatalf("Start delivery %d: expected 204, got %d", i+1, rec.Code)
+		}
+	}
+	started := periodEnd()
+
+	for i := range 2 {
+		rec := sendWebhook(t, mux, subscriptionEvent("evt_renewed", "subscription.renewed", subscriber.UserID, nil), now.Add(time.Duration(i)*time.Second))
+		if rec.Code != 204 {
+			t.Fatalf("Renewal delivery %d: expected 204, got %d", i+1, rec.Code)
+		}
+	}
+	if renewed := periodEnd(); !renewed.Equal(started.Add(chirpyRedBillingPeriod)) {
+		t.Fatalf("Expected one period added to %s, got an end of %s", started, renewed)
+	}
+
+	processed := webhookEvents(webhookProcessed)
+	for _, eventID := range []string{"evt_started", "evt_renewed"} {
+		if processed[eventID].Attempts != 1 {
+			t.Fatalf("Expected %s to be processed once, got %+v", eventID, processed[eventID])
+		}
+	}
+
+	// A cancel that arrives before the subscription it cancels fails, and can
+	// be replayed once the subscription exists
+	rec := sendWebhook(t, mux, subscriptionEvent("evt_early_cancel", "subscription.canceled", latecomer.UserID, nil), now)
+	if rec.Code != 404 {
+		t.Fatalf("Cancel without a subscription: expected 404, got %d", rec.Code)
+	}
+
+	failed, ok := webhookEvents(webhookFailed)["evt_early_cancel"]
+	if !ok {
+		t.Fatalf("Expected the early cancel to be stored as failed")
+	}
+	replayTarget := "/admin/webhooks/" + failed.ID.String() + "/replay"
+
+	rec = doJSON(t, mux, "POST", replayTarget, "", nil)
+	if rec.Code != 200 {
+		t.Fatalf("Replay that fails again: expected 200, got %d", rec.Code)
+	}
+	replayed := testWebhookEvent{}
+	decodeJSON(t, rec, &replayed)
+	if replayed.Status != webhookFailed || replayed.Attempts != 2 {
+		t.Fatalf("Expected a second failed attempt, got %+v", replayed)
+	}
+
+	rec = sendWebhook(t, mux, subscriptionEvent("evt_late_start", "subscription.started", latecomer.UserID, nil), now)
+	if rec.Code != 204 {
+		t.Fatalf("Start: expected 204, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "POST", replayTarget, "", nil)
+	if rec.Code != 200 {
+		t.Fatalf("Replay: expected 200, got %d", rec.Code)
+	}
+	decodeJSON(t, rec, &replayed)
+	if replayed.Status != webhookProcessed {
+		t.Fatalf("Expected the replay to be processed, got %+v", replayed)
+	}
+
+	subscription, err := cfg.Db.GetSubscription(context.Background(), latecomer.UserID)
+	if err != nil {
+		t.Fatalf("Error getting subscription: %s", err)
+	}
+	if subscription.Status != "canceled" {
+		t.Fatalf("Expected the replayed cancel to apply, got %s", subscription.Status)
+	}
+
+	// Processed events, and the provider redelivering them, change nothing
+	rec = doJSON(t, mux, "POST", replayTarget, "", nil)
+	if rec.Code != 409 {
+		t.Fatalf("Replay of a processed event: expected 409, got %d", rec.Code)
+	}
+
+	rec = sendWebhook(t, mux, subscriptionEvent("evt_early_cancel", "subscription.canceled", latecomer.UserID, nil), now.Add(time.Second))
+	if rec.Code != 204 {
+		t.Fatalf("Redelivery of a processed event: expected 204, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, mux, "POST", "/admin/webhooks/"+uuid.NewString()+"/replay", "", nil)
+	if rec.Code != 404 {
+		t.Fatalf("Replay of an unknown event: expected 404, got %d", rec.Code)
+	}
+}
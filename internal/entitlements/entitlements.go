@@ -0,0 +1,59 @@
+package entitlements
+
+import "time"
+
+// Plan is what a user pays for.
+type Plan string
+
+const (
+	PlanFree      Plan = "free"
+	PlanChirpyRed Plan = "chirpy_red"
+)
+
+// Entitlements are what a plan lets a user do. Handlers check these rather
+// than hard-coding limits so plans can change in one place.
+type Entitlements struct {
+	Plan           Plan
+	MaxChirpLength int
+	// EditWindow is how long after posting a chirp can still be edited.
+	EditWindow time.Duration
+	// ChirpsPerHour caps how many chirps can be posted in any hour.
+	ChirpsPerHour int
+}
+
+// Catalog holds the entitlements of every plan.
+type Catalog map[Plan]Entitlements
+
+func DefaultCatalog() Catalog {
+	return Catalog{
+		PlanFree: {
+			Plan:           PlanFree,
+			MaxChirpLength: 140,
+			EditWindow:     5 * time.Minute,
+			ChirpsPerHour:  30,
+		},
+		PlanChirpyRed: {
+			Plan:           PlanChirpyRed,
+			MaxChirpLength: 500,
+			EditWindow:     time.Hour,
+			ChirpsPerHour:  300,
+		},
+	}
+}
+
+// PlanFor is the plan of a user with or without a Chirpy Red subscription.
+func PlanFor(chirpyRed bool) Plan {
+	if chirpyRed {
+		return PlanChirpyRed
+	}
+	return PlanFree
+}
+
+// For returns the entitlements of plan. A plan missing from the catalog gets
+// the free plan's entitlements, so a mistake never grants more than intended.
+func (c Catalog) For(plan Plan) Entitlements {
+	if entitlements, ok := c[plan]; ok {
+		return entitlements
+	}
+	return c[PlanFree]
+}
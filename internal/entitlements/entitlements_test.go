@@ -0,0 +1,30 @@
+package entitlements
+
+import "testing"
+
+func TestCatalog(t *testing.T) {
+	catalog := DefaultCatalog()
+
+	free := catalog.For(PlanFor(false))
+	red := catalog.For(PlanFor(true))
+
+	if free.Plan != PlanFree || red.Plan != PlanChirpyRed {
+		t.Fatalf("Wrong plans: %s, %s", free.Plan, red.Plan)
+	}
+
+	if free.MaxChirpLength != 140 {
+		t.Fatalf("Free plan chirp limit changed: %d", free.MaxChirpLength)
+	}
+
+	if red.MaxChirpLength <= free.MaxChirpLength || red.EditWindow <= free.EditWindow || red.ChirpsPerHour <= free.ChirpsPerHour {
+		t.Fatalf("Chirpy Red does not improve on the free plan: %+v", red)
+	}
+}
+
+func TestCatalogUnknownPlan(t *testing.T) {
+	catalog := DefaultCatalog()
+
+	if got := catalog.For("enterprise"); got != catalog[PlanFree] {
+		t.Fatalf("Unknown plan got %+v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := &MemoryMailer{}
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != "user@example.com" {
+		t.Fatalf("message not recorded")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := FileMailer{Dir: dir, From: "chirpy@example.com"}
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Verify", Body: "Click here"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one message file, got %d", len(files))
+	}
+
+	dat, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !strings.Contains(string(dat), "To: user@example.com\r\n") || !strings.Contains(string(dat), "Click here") {
+		t.Fatalf("message file missing content: %s", dat)
+	}
+}
+
+func TestHeaderInjectionRejected(t *testing.T) {
+	mailer := &MemoryMailer{}
+
+	err := mailer.Send(context.Background(), Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hi"})
+	if err == nil {
+		t.Fatalf("Sent message with line breaks in headers")
+	}
+}
@@ -0,0 +1,46 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursor marks a position in a list sorted by (created_at, id). Clients get
+// it as an opaque string and hand it back to fetch the next or previous page.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+	// Backward cursors fetch the page before the item rather than after it.
+	Backward bool
+}
+
+type encodedCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	// Marshalling a struct of times, UUIDs and bools cannot fail
+	dat, _ := json.Marshal(encodedCursor{CreatedAt: c.CreatedAt, ID: c.ID, Backward: c.Backward})
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func DecodeCursor(cursor string) (Cursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Cursor{}, errors.New("malformed cursor")
+	}
+
+	decoded := encodedCursor{}
+	err = json.Unmarshal(dat, &decoded)
+	if err != nil || decoded.CreatedAt.IsZero() || decoded.ID == uuid.Nil {
+		return Cursor{}, errors.New("malformed cursor")
+	}
+
+	return Cursor{CreatedAt: decoded.CreatedAt, ID: decoded.ID, Backward: decoded.Backward}, nil
+}
@@ -0,0 +1,34 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{
+		CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        uuid.New(),
+		Backward:  true,
+	}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.ID != cursor.ID || decoded.Backward != cursor.Backward {
+		t.Fatalf("got %+v, want %+v", decoded, cursor)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, cursor := range []string{"", "not a cursor", "e30", Cursor{}.Encode()} {
+		_, err := DecodeCursor(cursor)
+		if err == nil {
+			t.Fatalf("Decoded invalid cursor %q", cursor)
+		}
+	}
+}
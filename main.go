@@ -1,16 +1,24 @@
 package main
 
 import (
+	"chirpy/internal/auth"
+	"chirpy/internal/billing"
 	"chirpy/internal/config"
 	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"chirpy/internal/mail"
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
@@ -28,12 +36,85 @@ func main() {
 		return
 	}
 
+	// Signing keys are stored encrypted, under their own secret if one is set
+	signingKeySecret := os.Getenv("SIGNING_KEY_SECRET")
+	if signingKeySecret == "" {
+		signingKeySecret = tokenSecret
+	}
+
+	paymentProviders := map[string]billing.Provider{}
 	polkaKey := os.Getenv("POLKA_KEY")
-	if polkaKey == "" {
-		fmt.Println("error: no polka key")
+	polkaWebhookSecrets := splitList(os.Getenv("POLKA_WEBHOOK_SECRETS"))
+	if polkaKey != "" || len(polkaWebhookSecrets) > 0 {
+		paymentProviders["polka"] = billing.Polka{APIKey: polkaKey, Secrets: polkaWebhookSecrets}
+	}
+	if secrets := splitList(os.Getenv("BILLING_WEBHOOK_SECRETS")); len(secrets) > 0 {
+		paymentProviders["generic"] = billing.Generic{Secrets: secrets}
+	}
+	if len(paymentProviders) == 0 {
+		fmt.Println("error: no payment provider configured")
 		return
 	}
 
+	signingAlg := os.Getenv("JWT_SIGNING_ALG")
+	if signingAlg == "" {
+		signingAlg = auth.AlgRS256
+	}
+	if signingAlg != auth.AlgRS256 && signingAlg != auth.AlgEdDSA {
+		fmt.Printf("error: unsupported JWT_SIGNING_ALG %s\n", signingAlg)
+		return
+	}
+
+	keyRotationInterval := 30 * 24 * time.Hour
+	if interval := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil {
+			fmt.Printf("error: invalid JWT_KEY_ROTATION_INTERVAL: %v\n", err)
+			return
+		}
+		keyRotationInterval = parsed
+	}
+
+	catalog := entitlements.DefaultCatalog()
+	editWindows := map[string]entitlements.Plan{
+		"CHIRP_EDIT_WINDOW":      entitlements.PlanFree,
+		"CHIRPY_RED_EDIT_WINDOW": entitlements.PlanChirpyRed,
+	}
+	for name, plan := range editWindows {
+		if window := os.Getenv(name); window != "" {
+			parsed, err := time.ParseDuration(window)
+			if err != nil {
+				fmt.Printf("error: invalid %s: %v\n", name, err)
+				return
+			}
+			ents := catalog[plan]
+			ents.EditWindow = parsed
+			catalog[plan] = ents
+		}
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	var mailer mail.Mailer
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mailer = mail.SMTPMailer{
+			Addr:     smtpAddr,
+			From:     os.Getenv("MAIL_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	} else {
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "outbox"
+		}
+		fmt.Printf("No SMTP_ADDR set, writing outgoing mail to %s\n", mailDir)
+		mailer = mail.FileMailer{Dir: mailDir, From: os.Getenv("MAIL_FROM")}
+	}
+
 	serveMux := http.NewServeMux()
 
 	server := http.Server{
@@ -41,7 +122,7 @@ func main() {
 		Addr:    ":8080",
 	}
 	fmt.Printf("Connecting with string: %s\n", dbURL)
-	db, err := sql.Open("postgres", dbURL)
+	db, err := sql.Open("postgres", config.WithSessionParam(dbURL, "timezone", "UTC"))
 	if err != nil {
 		fmt.Println(err.Error())
 	}
@@ -57,29 +138,144 @@ func main() {
 
 	dbQueries := database.New(db)
 
+	var oidcProvider *auth.OIDCProvider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		oidcProvider, err = auth.DiscoverOIDCProvider(
+			context.Background(),
+			&http.Client{Timeout: 10 * time.Second},
+			issuer,
+			os.Getenv("OIDC_CLIENT_ID"),
+			os.Getenv("OIDC_CLIENT_SECRET"),
+			baseURL+"/api/login/oidc/callback",
+		)
+		if err != nil {
+			fmt.Printf("Error discovering OIDC provider: %v\n", err)
+			return
+		}
+	}
+
+	revocations := auth.NewRevocationList()
+	keys := auth.NewKeyring()
+	keys.SetRevocationList(revocations)
+	keys.SetActivationDelay(config.SigningKeyActivationDelay)
+
 	cfg := config.ApiConfig{
-		FileserverHits: atomic.Int32{},
-		Db:             *dbQueries,
-		TokenSecret:    tokenSecret,
-		PolkaKey:       polkaKey,
+		FileserverHits:   atomic.Int32{},
+		Db:               *dbQueries,
+		Conn:             db,
+		SigningKeySecret: signingKeySecret,
+		TokenSecret:      tokenSecret,
+		IntrospectionKey: os.Getenv("INTROSPECTION_KEY"),
+		Keys:             keys,
+		Revocations:      revocations,
+		SigningAlgorithm: signingAlg,
+		Passwords: auth.NewPasswordHasher(
+			auth.Argon2idHasher{Params: auth.DefaultArgon2idParams},
+			auth.BcryptHasher{Cost: bcrypt.DefaultCost},
+		),
+		Mailer:               mailer,
+		BaseURL:              baseURL,
+		RequireVerifiedEmail: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		TrustProxyHeaders:    os.Getenv("TRUST_PROXY_HEADERS") == "true",
+		OIDC:                 oidcProvider,
+		PaymentProviders:     paymentProviders,
+		Entitlements:         catalog,
 	}
 
+	err = cfg.LoadSigningKeys(context.Background())
+	if err != nil {
+		fmt.Printf("Error loading signing keys: %v\n", err)
+		return
+	}
+
+	if newest, err := cfg.Keys.Newest(); err != nil || newest.Algorithm != signingAlg {
+		err = cfg.RotateSigningKey(context.Background())
+		if err != nil {
+			fmt.Printf("Error creating signing key: %v\n", err)
+			return
+		}
+	}
+
+	go cfg.RotateSigningKeysEvery(context.Background(), keyRotationInterval)
+
+	err = cfg.HashLegacyRefreshTokens(context.Background())
+	if err != nil {
+		fmt.Printf("Error hashing stored refresh tokens: %v\n", err)
+		return
+	}
+
+	err = cfg.LoadRevocations(context.Background())
+	if err != nil {
+		fmt.Printf("Error loading token revocations: %v\n", err)
+		return
+	}
+
+	if adminEmail := os.Getenv("ADMIN_EMAIL"); adminEmail != "" {
+		err = cfg.BootstrapAdmin(context.Background(), adminEmail)
+		if err != nil {
+			fmt.Printf("Error promoting admin: %v\n", err)
+			return
+		}
+	}
+
+	go cfg.SyncRevocationsEvery(context.Background(), 10*time.Second)
+	go cfg.SweepSubscriptionsEvery(context.Background(), time.Hour)
+
 	serveMux.Handle("/app/", http.StripPrefix("/app", cfg.MiddlewareMetricsInc(http.FileServer(http.Dir(".")))))
 
+	serveMux.Handle("GET /.well-known/jwks.json", http.HandlerFunc(cfg.JWKSHandler))
 	serveMux.Handle("GET /api/healthz", http.HandlerFunc(config.HealthHandler))
 	serveMux.Handle("GET /api/chirps", http.HandlerFunc(cfg.GetChirpsHandler))
 	serveMux.Handle("GET /api/chirps/{chirpID}", http.HandlerFunc(cfg.GetChirpHandler))
 	serveMux.Handle("DELETE /api/chirps/{chirpID}", http.HandlerFunc(cfg.DeleteChirpHandler))
+	serveMux.Handle("PATCH /api/chirps/{chirpID}", http.HandlerFunc(cfg.EditChirpHandler))
+	serveMux.Handle("GET /api/chirps/{chirpID}/revisions", http.HandlerFunc(cfg.ChirpRevisionsHandler))
 	serveMux.Handle("POST /api/chirps", http.HandlerFunc(cfg.ChirpsHandler))
 	serveMux.Handle("POST /api/users", http.HandlerFunc(cfg.UsersHandler))
+	serveMux.Handle("GET /api/users/verify", http.HandlerFunc(cfg.VerifyEmailHandler))
+	serveMux.Handle("POST /api/users/verify/resend", http.HandlerFunc(cfg.ResendVerificationHandler))
 	serveMux.Handle("PUT /api/users", http.HandlerFunc(cfg.UsersPutHandler))
 	serveMux.Handle("POST /api/login", http.HandlerFunc(cfg.LoginHandler))
+	serveMux.Handle("POST /api/login/magic", http.HandlerFunc(cfg.MagicLinkHandler))
+	serveMux.Handle("POST /api/login/magic/verify", http.HandlerFunc(cfg.MagicLinkLoginHandler))
+	serveMux.Handle("GET /api/login/oidc", http.HandlerFunc(cfg.OIDCLoginHandler))
+	serveMux.Handle("GET /api/login/oidc/callback", http.HandlerFunc(cfg.OIDCCallbackHandler))
+	serveMux.Handle("POST /api/login/mfa", http.HandlerFunc(cfg.LoginMFAHandler))
+	serveMux.Handle("POST /api/mfa/totp/enroll", http.HandlerFunc(cfg.TOTPEnrollHandler))
+	serveMux.Handle("POST /api/mfa/totp/confirm", http.HandlerFunc(cfg.TOTPConfirmHandler))
+	serveMux.Handle("POST /api/mfa/totp/disable", http.HandlerFunc(cfg.TOTPDisableHandler))
+	serveMux.Handle("POST /api/password/forgot", http.HandlerFunc(cfg.ForgotPasswordHandler))
+	serveMux.Handle("POST /api/password/reset", http.HandlerFunc(cfg.ResetPasswordHandler))
 	serveMux.Handle("POST /api/refresh", http.HandlerFunc(cfg.RefreshHandler))
 	serveMux.Handle("POST /api/revoke", http.HandlerFunc(cfg.RevokeHandler))
+	serveMux.Handle("GET /api/sessions", http.HandlerFunc(cfg.SessionsHandler))
+	serveMux.Handle("DELETE /api/sessions/{sessionID}", http.HandlerFunc(cfg.DeleteSessionHandler))
+	serveMux.Handle("POST /api/sessions/revoke-others", http.HandlerFunc(cfg.RevokeOtherSessionsHandler))
+	serveMux.Handle("POST /api/tokens", http.HandlerFunc(cfg.CreatePersonalAccessTokenHandler))
+	serveMux.Handle("GET /api/tokens", http.HandlerFunc(cfg.PersonalAccessTokensHandler))
+	serveMux.Handle("DELETE /api/tokens/{tokenID}", http.HandlerFunc(cfg.RevokePersonalAccessTokenHandler))
+	serveMux.Handle("GET /api/me", http.HandlerFunc(cfg.MeHandler))
+	serveMux.Handle("POST /api/oauth/introspect", http.HandlerFunc(cfg.IntrospectHandler))
+	serveMux.Handle("POST /api/oauth/clients", http.HandlerFunc(cfg.CreateOAuthClientHandler))
+	serveMux.Handle("GET /api/oauth/clients", http.HandlerFunc(cfg.OAuthClientsHandler))
+	serveMux.Handle("DELETE /api/oauth/clients/{clientID}", http.HandlerFunc(cfg.DeleteOAuthClientHandler))
+	serveMux.Handle("GET /oauth/authorize", http.HandlerFunc(cfg.AuthorizeHandler))
+	serveMux.Handle("POST /oauth/authorize", http.HandlerFunc(cfg.AuthorizeConsentHandler))
+	serveMux.Handle("POST /oauth/token", http.HandlerFunc(cfg.TokenHandler))
+	serveMux.Handle("POST /oauth/revoke", http.HandlerFunc(cfg.OAuthRevokeHandler))
 	serveMux.Handle("POST /api/polka/webhooks", http.HandlerFunc(cfg.ChirpyRedHandler))
+	serveMux.Handle("POST /api/webhooks/{provider}", http.HandlerFunc(cfg.WebhookHandler))
 
-	serveMux.Handle("GET /admin/metrics", http.HandlerFunc(cfg.MetricsHandler))
-	serveMux.Handle("POST /admin/reset", http.HandlerFunc(cfg.ResetMetricsHandler))
+	serveMux.Handle("GET /admin/metrics", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.MetricsHandler)))
+	serveMux.Handle("POST /admin/reset", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.ResetMetricsHandler)))
+	serveMux.Handle("GET /admin/lockouts", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.LockoutsHandler)))
+	serveMux.Handle("DELETE /admin/lockouts/{key}", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.ClearLockoutHandler)))
+	serveMux.Handle("PUT /admin/users/{userID}/role", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.SetUserRoleHandler)))
+	serveMux.Handle("POST /admin/users/{userID}/suspend", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.SuspendUserHandler)))
+	serveMux.Handle("POST /admin/users/{userID}/unsuspend", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.UnsuspendUserHandler)))
+	serveMux.Handle("GET /admin/audit", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.AuditEventsHandler)))
+	serveMux.Handle("GET /admin/webhooks", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.WebhookEventsHandler)))
+	serveMux.Handle("POST /admin/webhooks/{eventID}/replay", cfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(cfg.ReplayWebhookEventHandler)))
 
 	err = server.ListenAndServe()
 	if err != nil {
@@ -87,3 +283,14 @@ func main() {
 	}
 
 }
+
+// splitList splits a comma-separated environment variable, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
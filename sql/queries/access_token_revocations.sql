@@ -0,0 +1,14 @@
+-- name: RevokeAccessTokens :exec
+INSERT INTO access_token_revocations (key, revoked_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (key) DO UPDATE SET revoked_at = EXCLUDED.revoked_at, expires_at = EXCLUDED.expires_at;
+
+-- name: GetAccessTokenRevocations :many
+SELECT * FROM access_token_revocations WHERE expires_at > NOW();
+
+-- name: DeleteExpiredAccessTokenRevocations :exec
+DELETE FROM access_token_revocations WHERE expires_at <= NOW();
\ No newline at end of file
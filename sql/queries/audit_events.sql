@@ -0,0 +1,24 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (id, created_at, event, outcome, actor_id, subject_id, ip, user_agent, details)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: GetAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('event')::text IS NULL OR event = sqlc.narg('event'))
+AND (sqlc.narg('outcome')::text IS NULL OR outcome = sqlc.narg('outcome'))
+AND (sqlc.narg('actor_id')::uuid IS NULL OR actor_id = sqlc.narg('actor_id'))
+AND (sqlc.narg('subject_id')::uuid IS NULL OR subject_id = sqlc.narg('subject_id'))
+AND (sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since'))
+AND (sqlc.narg('until')::timestamptz IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY created_at DESC, id DESC
+LIMIT @row_limit OFFSET @row_offset;
\ No newline at end of file
@@ -0,0 +1,17 @@
+-- name: EditChirp :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, chirp_id, revision, body, created_at, replaced_at)
+    SELECT gen_random_uuid (), id, revision_count, body, updated_at, NOW()
+    FROM chirps WHERE chirps.id = $1
+)
+UPDATE chirps SET body = $2, updated_at = NOW(), revision_count = revision_count + 1
+WHERE chirps.id = $1
+RETURNING *;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions WHERE chirp_id = $1 ORDER BY revision ASC;
+
+-- name: CountChirpEditsInLastHour :one
+SELECT COUNT(*) FROM chirp_revisions
+JOIN chirps ON chirps.id = chirp_revisions.chirp_id
+WHERE chirps.user_id = $1 AND chirp_revisions.replaced_at > NOW() - INTERVAL '1 hour';
\ No newline at end of file
@@ -7,4 +7,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: CountChirpsInLastHour :one
+SELECT COUNT(*) FROM chirps WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 hour';
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- name: ConsumeToken :execrows
+INSERT INTO consumed_tokens (id, expires_at)
+VALUES (
+    $1,
+    $2
+)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteExpiredConsumedTokens :exec
+DELETE FROM consumed_tokens WHERE expires_at < NOW();
\ No newline at end of file
@@ -1,2 +1,19 @@
--- name: GetChirps :many
-SELECT * FROM chirps WHERE (user_id = $1 OR @skip::bool) ORDER BY CASE WHEN @order_by::text = 'desc' THEN created_at END DESC, CASE WHEN @order_by::text != 'desc' THEN created_at END ASC;
\ No newline at end of file
+-- name: GetChirpsAsc :many
+SELECT * FROM chirps
+WHERE (user_id = @user_id OR @skip::bool)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at, id
+LIMIT @row_limit;
+
+-- name: GetChirpsDesc :many
+SELECT * FROM chirps
+WHERE (user_id = @user_id OR @skip::bool)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT @row_limit;
\ No newline at end of file
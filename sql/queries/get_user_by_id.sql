@@ -0,0 +1,2 @@
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1 LIMIT 1;
\ No newline at end of file
@@ -1,2 +1,2 @@
 -- name: GetUserFromRefreshToken :one
-SELECT users.id FROM refresh_tokens LEFT JOIN users ON refresh_tokens.user_id = users.id WHERE refresh_tokens.token = $1 AND (refresh_tokens.expires_at > NOW() OR refresh_tokens.expires_at IS NULL);
\ No newline at end of file
+SELECT users.id FROM refresh_tokens LEFT JOIN users ON refresh_tokens.user_id = users.id WHERE refresh_tokens.token_hash = $1 AND refresh_tokens.revoked_at IS NULL AND (refresh_tokens.expires_at > NOW() OR refresh_tokens.expires_at IS NULL);
\ No newline at end of file
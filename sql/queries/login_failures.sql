@@ -0,0 +1,24 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_failures (key, failures, last_failed_at, locked_until)
+VALUES (
+    @key,
+    1,
+    NOW(),
+    NULL
+)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_failures.last_failed_at < @window_start::timestamptz THEN 1 ELSE login_failures.failures + 1 END,
+    last_failed_at = NOW()
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_failures SET locked_until = $2 WHERE key = $1;
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_failures WHERE key = $1;
+
+-- name: GetLockedLogins :many
+SELECT * FROM login_failures WHERE locked_until > NOW() ORDER BY locked_until DESC;
\ No newline at end of file
@@ -0,0 +1,78 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_id, name, secret_hash, redirect_uris, scopes, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW()
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: GetUserOAuthClients :many
+SELECT * FROM oauth_clients WHERE owner_id = $1 ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1 AND owner_id = $2;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, code_challenge_method, created_at, expires_at, used_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    NOW(),
+    $8,
+    NULL
+);
+
+-- name: ConsumeAuthorizationCode :one
+UPDATE oauth_authorization_codes SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: GetAuthorizationCode :one
+SELECT * FROM oauth_authorization_codes WHERE code_hash = $1;
+
+-- name: CreateOAuthRefreshToken :one
+INSERT INTO oauth_refresh_tokens (token_hash, token_prefix, client_id, user_id, code_hash, scopes, created_at, expires_at, revoked_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    NOW() + INTERVAL '60 days',
+    NULL
+)
+RETURNING *;
+
+-- name: RotateOAuthRefreshToken :one
+UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+WHERE token_hash = $1 AND client_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: RevokeOAuthRefreshToken :one
+UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+WHERE token_hash = $1 AND client_id = $2 AND revoked_at IS NULL
+RETURNING user_id;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_refresh_tokens SET revoked_at = NOW() WHERE code_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserOAuthGrants :exec
+UPDATE oauth_refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: GetOAuthRefreshToken :one
+SELECT * FROM oauth_refresh_tokens WHERE token_hash = $1;
\ No newline at end of file
@@ -0,0 +1,31 @@
+-- name: GetUserByIdentity :one
+SELECT users.* FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.issuer = $1 AND user_identities.subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (id, user_id, issuer, subject, email, created_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+);
+
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state, nonce, code_verifier, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW() + INTERVAL '10 minutes'
+);
+
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states WHERE state = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states WHERE expires_at <= NOW();
\ No newline at end of file
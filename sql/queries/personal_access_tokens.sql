@@ -0,0 +1,29 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, token_prefix, scopes, created_at, last_used_at, expires_at, revoked_at)
+VALUES (
+    gen_random_uuid (),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW(),
+    NULL,
+    $6,
+    NULL
+)
+RETURNING *;
+
+-- name: GetPersonalAccessToken :one
+SELECT * FROM personal_access_tokens
+WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+AND user_id IN (SELECT id FROM users WHERE suspended_at IS NULL);
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens SET last_used_at = NOW() WHERE id = $1;
+
+-- name: GetUserPersonalAccessTokens :many
+SELECT * FROM personal_access_tokens WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
\ No newline at end of file
@@ -1,11 +1,35 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, expires_at, revoked_at, user_id)
+INSERT INTO refresh_tokens (token_hash, token_prefix, created_at, updated_at, expires_at, revoked_at, user_id, family_id, user_agent, ip, device_label, last_used_at)
 VALUES (
     $1,
+    $2,
     NOW(),
     NOW(),
     NOW() + INTERVAL '60 days',
     NULL,
-    $2
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    NOW()
 )
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :one
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW(), replaced_by = $2
+WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: GetUnhashedRefreshTokens :many
+SELECT * FROM refresh_tokens WHERE token_prefix = '';
+
+-- name: HashRefreshToken :exec
+UPDATE refresh_tokens SET token_hash = @token_hash, token_prefix = @token_prefix, replaced_by = @replaced_by
+WHERE token_hash = @raw_token AND token_prefix = '';
\ No newline at end of file
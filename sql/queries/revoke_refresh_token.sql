@@ -1,3 +1,2 @@
--- name: RevokeRefreshToken :one
-UPDATE refresh_tokens SET expires_at = NOW(), updated_at = NOW() WHERE token = $1 
-RETURNING *;
\ No newline at end of file
+-- name: RevokeRefreshToken :execrows
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- name: SetUserRole :one
+UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2
+RETURNING *;
\ No newline at end of file
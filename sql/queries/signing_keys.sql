@@ -0,0 +1,23 @@
+-- name: CreateSigningKey :one
+INSERT INTO signing_keys (id, algorithm, private_key, created_at, retired_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    NULL
+)
+RETURNING *;
+
+-- name: GetSigningKeys :many
+SELECT * FROM signing_keys WHERE retired_at IS NULL OR retired_at > NOW() ORDER BY created_at DESC;
+
+-- name: RetireSigningKeys :exec
+UPDATE signing_keys SET retired_at = $1 WHERE id != $2 AND retired_at IS NULL;
+
+-- name: DeleteRetiredSigningKeys :exec
+DELETE FROM signing_keys WHERE retired_at <= NOW();
+
+
+-- name: SealSigningKey :exec
+UPDATE signing_keys SET private_key = $2 WHERE id = $1;
\ No newline at end of file
@@ -0,0 +1,40 @@
+-- name: StartSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, current_period_start, current_period_end, canceled_at)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1,
+    'active',
+    $2,
+    $3,
+    NULL
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(), status = 'active', current_period_start = EXCLUDED.current_period_start, current_period_end = EXCLUDED.current_period_end, canceled_at = NULL
+RETURNING *;
+
+-- name: GetSubscription :one
+SELECT * FROM subscriptions WHERE user_id = $1;
+
+-- name: MarkSubscriptionPastDue :execrows
+UPDATE subscriptions SET updated_at = NOW(), status = 'past_due'
+WHERE user_id = $1 AND status IN ('active', 'past_due');
+
+-- name: CancelSubscription :execrows
+UPDATE subscriptions SET updated_at = NOW(), status = 'canceled', canceled_at = NOW()
+WHERE user_id = $1 AND status <> 'expired';
+
+-- name: EndSubscription :execrows
+UPDATE subscriptions SET updated_at = NOW(), status = 'expired', current_period_end = LEAST(current_period_end, NOW())
+WHERE user_id = $1;
+
+-- name: ExpireSubscriptions :execrows
+UPDATE subscriptions SET updated_at = NOW(), status = 'expired'
+WHERE status <> 'expired' AND current_period_end <= NOW();
+
+-- name: UserHasChirpyRed :one
+SELECT EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE user_id = $1 AND status <> 'expired' AND current_period_end > NOW()
+);
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- name: SuspendUser :execrows
+UPDATE users SET suspended_at = NOW(), updated_at = NOW() WHERE id = $1 AND suspended_at IS NULL;
+
+-- name: UnsuspendUser :execrows
+UPDATE users SET suspended_at = NULL, updated_at = NOW() WHERE id = $1 AND suspended_at IS NOT NULL;
\ No newline at end of file
@@ -1,3 +1,7 @@
 -- name: UpdateUser :one
-UPDATE users SET email = $1, hashed_password = $2 WHERE id = $3
+UPDATE users SET
+    email = $1,
+    hashed_password = $2,
+    email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
+WHERE id = $3
 RETURNING *;
\ No newline at end of file
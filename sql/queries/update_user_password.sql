@@ -0,0 +1,2 @@
+-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password = $1 WHERE id = $2;
\ No newline at end of file
@@ -1,2 +0,0 @@
--- name: UpgradeChirpyRed :exec
-UPDATE users SET is_chirpy_red = TRUE WHERE id = $1;
\ No newline at end of file
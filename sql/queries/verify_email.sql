@@ -0,0 +1,2 @@
+-- name: VerifyEmail :execrows
+UPDATE users SET email_verified_at = NOW() WHERE id = $1 AND email = $2;
\ No newline at end of file
@@ -0,0 +1,37 @@
+-- name: RecordWebhookEvent :one
+INSERT INTO webhook_events (id, created_at, updated_at, provider, event_id, event, payload, status)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    'pending'
+)
+ON CONFLICT (provider, event_id) DO UPDATE SET updated_at = webhook_events.updated_at
+RETURNING *;
+
+-- name: ClaimWebhookEvent :one
+UPDATE webhook_events SET updated_at = NOW(), status = 'processing', attempts = attempts + 1
+WHERE id = $1
+AND (status IN ('pending', 'failed') OR (status = 'processing' AND updated_at < NOW() - INTERVAL '5 minutes'))
+RETURNING *;
+
+-- name: MarkWebhookEventDone :exec
+UPDATE webhook_events SET updated_at = NOW(), status = $2, last_error = '', processed_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookEventFailed :exec
+UPDATE webhook_events SET updated_at = NOW(), status = 'failed', last_error = $2
+WHERE id = $1;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events WHERE id = $1;
+
+-- name: GetWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+ORDER BY created_at DESC, id DESC
+LIMIT @row_limit OFFSET @row_offset;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Existing tokens keep their raw value under token_hash, marked by an empty
+-- token_prefix, until the server hashes them when it next starts. The key it
+-- hashes with (TOKEN_SECRET) is never handed to SQL.
+ALTER TABLE refresh_tokens ADD COLUMN token_prefix TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ALTER COLUMN token_prefix DROP DEFAULT;
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+-- +goose Down
+-- Hashed tokens cannot be recovered, so every session has to log in again.
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
+ALTER TABLE refresh_tokens DROP COLUMN token_prefix;
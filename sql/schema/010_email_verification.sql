@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+CREATE TABLE consumed_tokens(
+    id TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE consumed_tokens;
+ALTER TABLE users DROP COLUMN email_verified_at;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE login_failures(
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE login_failures;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE access_token_revocations(
+    key TEXT PRIMARY KEY,
+    revoked_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN suspended_at;
+DROP TABLE access_token_revocations;
\ No newline at end of file
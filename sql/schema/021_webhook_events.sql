@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE webhook_events(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    processed_at TIMESTAMP,
+    UNIQUE(provider, event_id)
+);
+
+CREATE INDEX webhook_events_status_idx ON webhook_events(status);
+
+-- +goose Down
+DROP TABLE webhook_events;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX chirps_user_id_created_at_idx ON chirps(user_id, created_at);
+
+-- +goose Down
+DROP INDEX chirps_user_id_created_at_idx;
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE chirp_revisions(
+    id UUID PRIMARY KEY,
+    chirp_id UUID NOT NULL,
+    revision INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP NOT NULL,
+    UNIQUE(chirp_id, revision),
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id) ON DELETE CASCADE
+);
+
+ALTER TABLE chirps ADD COLUMN revision_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN edited BOOLEAN GENERATED ALWAYS AS (revision_count > 0) STORED;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN edited;
+ALTER TABLE chirps DROP COLUMN revision_count;
+DROP TABLE chirp_revisions;
\ No newline at end of file
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Compare audit times as instants, whatever time zone the session or the
+-- caller of GET /admin/audit uses. Existing rows were written by NOW(), so
+-- they are read in the session's time zone.
+ALTER TABLE audit_events ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE audit_events ALTER COLUMN created_at TYPE TIMESTAMP;
\ No newline at end of file
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Periods come from Go and from payment providers as instants, and are
+-- compared with NOW() in queries; storing them with a time zone keeps the
+-- two in step whatever the session time zone. Existing values are UTC.
+ALTER TABLE subscriptions
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE 'UTC',
+    ALTER COLUMN current_period_start TYPE TIMESTAMPTZ USING current_period_start AT TIME ZONE 'UTC',
+    ALTER COLUMN current_period_end TYPE TIMESTAMPTZ USING current_period_end AT TIME ZONE 'UTC',
+    ALTER COLUMN canceled_at TYPE TIMESTAMPTZ USING canceled_at AT TIME ZONE 'UTC';
+
+-- +goose Down
+ALTER TABLE subscriptions
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'UTC',
+    ALTER COLUMN current_period_start TYPE TIMESTAMP USING current_period_start AT TIME ZONE 'UTC',
+    ALTER COLUMN current_period_end TYPE TIMESTAMP USING current_period_end AT TIME ZONE 'UTC',
+    ALTER COLUMN canceled_at TYPE TIMESTAMP USING canceled_at AT TIME ZONE 'UTC';
\ No newline at end of file
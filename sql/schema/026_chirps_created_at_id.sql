@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX chirps_created_at_id_idx ON chirps(created_at, id);
+
+-- +goose Down
+DROP INDEX chirps_created_at_id_idx;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Store failure and lock times as instants, like audit_events and
+-- subscriptions. Existing values were written in UTC.
+ALTER TABLE login_failures
+    ALTER COLUMN last_failed_at TYPE TIMESTAMPTZ USING last_failed_at AT TIME ZONE 'UTC',
+    ALTER COLUMN locked_until TYPE TIMESTAMPTZ USING locked_until AT TIME ZONE 'UTC';
+
+-- +goose Down
+ALTER TABLE login_failures
+    ALTER COLUMN last_failed_at TYPE TIMESTAMP USING last_failed_at AT TIME ZONE 'UTC',
+    ALTER COLUMN locked_until TYPE TIMESTAMP USING locked_until AT TIME ZONE 'UTC';
\ No newline at end of file